@@ -0,0 +1,135 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrefixAssignment restricts a prefix to children in some namespaces.
+type PrefixAssignment struct {
+	// Prefix is the assigned prefix. Paths under it are assigned as well.
+	// +kubebuilder:validation:MinLength=1
+	Prefix string `json:"prefix"`
+
+	// Namespaces lists the namespaces of the children that may claim the
+	// prefix.
+	// +kubebuilder:validation:MinItems=1
+	Namespaces []string `json:"namespaces"`
+}
+
+// InclusionPolicySpec defines the desired state of InclusionPolicy
+type InclusionPolicySpec struct {
+	// ParentSelector selects the parent HTTPProxy objects the policy applies
+	// to by their labels. It may not be empty. Selected HTTPProxy objects
+	// allow child inclusions, as if annotated with allow-inclusion, unless
+	// they are explicitly annotated otherwise.
+	// +kubebuilder:validation:MinProperties=1
+	ParentSelector *metav1.LabelSelector `json:"parentSelector"`
+
+	// ParentNamespaceSelector restricts the policy to parent HTTPProxy
+	// objects in namespaces matching the selector.
+	// +optional
+	ParentNamespaceSelector *metav1.LabelSelector `json:"parentNamespaceSelector,omitempty"`
+
+	// AllowedNamespaces lists the namespaces children may be included from.
+	// If neither AllowedNamespaces nor AllowedNamespaceSelector is set,
+	// children may be included from any namespace.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// AllowedNamespaceSelector selects the namespaces children may be
+	// included from, in addition to AllowedNamespaces.
+	// +optional
+	AllowedNamespaceSelector *metav1.LabelSelector `json:"allowedNamespaceSelector,omitempty"`
+
+	// ChildSelector selects the child HTTPProxy objects that may be included
+	// by their labels. If unset, any child may be included.
+	// +optional
+	ChildSelector *metav1.LabelSelector `json:"childSelector,omitempty"`
+
+	// AllowedPrefixes lists the prefixes children may claim, along with the
+	// paths under them. If unset, children may claim any prefix that is not
+	// reserved.
+	// +optional
+	AllowedPrefixes []string `json:"allowedPrefixes,omitempty"`
+
+	// ReservedPrefixes lists the prefixes children may not claim, along with
+	// the paths under them. The root prefix "/" only reserves itself.
+	// +optional
+	ReservedPrefixes []string `json:"reservedPrefixes,omitempty"`
+
+	// PrefixPatterns lists the prefixes children may claim, along with the
+	// paths under them, where {namespace} and {name} are replaced with the
+	// namespace and name of the child. If unset, children may claim any
+	// prefix that is otherwise allowed.
+	// +optional
+	PrefixPatterns []string `json:"prefixPatterns,omitempty"`
+
+	// AssignedPrefixes restricts prefixes, along with the paths under them,
+	// to children in the given namespaces.
+	// +optional
+	AssignedPrefixes []PrefixAssignment `json:"assignedPrefixes,omitempty"`
+
+	// RequireApproval keeps new inclusions in selected parents pending until
+	// they are listed in the approved-inclusions annotation of the parent.
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// MaxChildren is the maximum number of children included in each
+	// selected parent.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxChildren *int32 `json:"maxChildren,omitempty"`
+
+	// MaxPrefixesPerNamespace is the maximum number of prefixes claimed by
+	// the children in any single namespace, in each selected parent.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxPrefixesPerNamespace *int32 `json:"maxPrefixesPerNamespace,omitempty"`
+
+	// OverlapPolicy decides what happens to children claiming a prefix that
+	// overlaps with the prefix of a sibling, such as /api and /api/v1 or
+	// /apiv2. One of allow, warn or deny. Defaults to allow.
+	// +kubebuilder:validation:Enum=allow;warn;deny
+	// +optional
+	OverlapPolicy string `json:"overlapPolicy,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// InclusionPolicy is the Schema for the inclusionpolicies API
+type InclusionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec InclusionPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InclusionPolicyList contains a list of InclusionPolicy
+type InclusionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InclusionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InclusionPolicy{}, &InclusionPolicyList{})
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionAccepted reports whether the request has been applied to the
+	// child HTTPProxy.
+	ConditionAccepted = "Accepted"
+	// ConditionAttached reports whether the child HTTPProxy is included in
+	// the parent HTTPProxy.
+	ConditionAttached = "Attached"
+)
+
+// ParentReference references a parent HTTPProxy.
+type ParentReference struct {
+	// Namespace of the parent HTTPProxy.
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// Name of the parent HTTPProxy.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// HeaderMatchCondition matches requests on a header. Exactly one of Exact,
+// Contains, Present or NotPresent must be set.
+type HeaderMatchCondition struct {
+	// Name of the header to match, case-insensitive.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Exact matches requests whose header has this value.
+	// +optional
+	Exact string `json:"exact,omitempty"`
+
+	// Contains matches requests whose header contains this string.
+	// +optional
+	Contains string `json:"contains,omitempty"`
+
+	// Present matches requests that have the header.
+	// +optional
+	Present bool `json:"present,omitempty"`
+
+	// NotPresent matches requests that do not have the header.
+	// +optional
+	NotPresent bool `json:"notpresent,omitempty"`
+}
+
+// InclusionRequestSpec defines the desired state of InclusionRequest
+type InclusionRequestSpec struct {
+	// HTTPProxy is the name of the child HTTPProxy to include, in the same
+	// namespace as the InclusionRequest.
+	// +kubebuilder:validation:MinLength=1
+	HTTPProxy string `json:"httpProxy"`
+
+	// Parent is the HTTPProxy to include the child in.
+	Parent ParentReference `json:"parent"`
+
+	// Prefix is the prefix under which the child is included. Defaults to
+	// the name of the child HTTPProxy, unless Prefixes is set. Prefixes are
+	// validated and normalized like the prefix annotation of the child, a
+	// missing leading slash being added.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Prefixes are further prefixes under which the child is included, each
+	// with its own include in the parent, validated and normalized like
+	// Prefix.
+	// +optional
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// Headers are header conditions the child is included with, in addition
+	// to the prefix.
+	// +optional
+	Headers []HeaderMatchCondition `json:"headers,omitempty"`
+}
+
+// InclusionRequestStatus defines the observed state of InclusionRequest
+type InclusionRequestStatus struct {
+	// State is the inclusion state of the child HTTPProxy, one of Attached,
+	// Pending, Conflict, Rejected, Frozen or Detached.
+	// +optional
+	State string `json:"state,omitempty"`
+
+	// Prefix is the prefix the child HTTPProxy is included with, or a
+	// comma-separated list if it is included under several prefixes.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// ObservedGeneration is the generation of the InclusionRequest the status
+	// was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions hold the Accepted and Attached conditions of the request.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HTTPProxy",type=string,JSONPath=`.spec.httpProxy`
+//+kubebuilder:printcolumn:name="Parent Namespace",type=string,JSONPath=`.spec.parent.namespace`
+//+kubebuilder:printcolumn:name="Parent",type=string,JSONPath=`.spec.parent.name`
+//+kubebuilder:printcolumn:name="Prefix",type=string,JSONPath=`.status.prefix`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InclusionRequest is the Schema for the inclusionrequests API
+type InclusionRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InclusionRequestSpec   `json:"spec,omitempty"`
+	Status InclusionRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InclusionRequestList contains a list of InclusionRequest
+type InclusionRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InclusionRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InclusionRequest{}, &InclusionRequestList{})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	requireApprovalAnnotation    = "oyako.atelierhsn.com/require-approval"
+	approvedInclusionsAnnotation = "oyako.atelierhsn.com/approved-inclusions"
+)
+
+// requiresApproval reports whether new inclusions in the parent must be
+// approved before being added.
+func (p *parentPolicy) requiresApproval() bool {
+	if p == nil {
+		return false
+	}
+	for _, rules := range p.Rules {
+		if rules.Spec.RequireApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// parseApprovals parses a comma-separated list of approved inclusions in the
+// namespace/name@prefix=approver format. It returns the approver of each
+// inclusion, keyed by approvalKey with the normalized prefix. Entries without
+// an approver or with a malformed prefix are ignored, so that every approval
+// can be traced back to someone.
+func parseApprovals(value string) map[string]string {
+	approvals := make(map[string]string)
+	for _, item := range splitList(value) {
+		idx := strings.Index(item, "=")
+		if idx < 0 {
+			continue
+		}
+		inclusion, approver := strings.TrimSpace(item[:idx]), strings.TrimSpace(item[idx+1:])
+		// Names cannot contain at signs, unlike prefixes.
+		at := strings.Index(inclusion, "@")
+		if at < 0 || approver == "" {
+			continue
+		}
+		prefix, err := normalizePrefix(inclusion[at+1:])
+		if err != nil {
+			continue
+		}
+		approvals[inclusion[:at]+"@"+prefix] = approver
+	}
+	return approvals
+}
+
+// approvalKey returns the inclusion of the child with prefix, as listed in
+// approvals.
+func approvalKey(key client.ObjectKey, prefix string) string {
+	return fmt.Sprintf("%s@%s", key, prefix)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// inclusionStatus is the inclusion status of a child HTTPProxy as seen by
+// oyako. It is published as JSON in the status annotation of the child, since
+// the status of HTTPProxy objects is owned by Contour.
+type inclusionStatus struct {
+	State              string  `json:"state"`
+	Parent             string  `json:"parent,omitempty"`
+	Prefix             string  `json:"prefix,omitempty"`
+	Reason             string  `json:"reason,omitempty"`
+	Message            string  `json:"message,omitempty"`
+	ObservedGeneration int64   `json:"observedGeneration"`
+	LastTransitionTime v1.Time `json:"lastTransitionTime"`
+	// FailedPrefixes lists the prefixes of an attached child that could not
+	// be included, along with their state and reason.
+	FailedPrefixes []prefixFailure `json:"failedPrefixes,omitempty"`
+}
+
+// getInclusionStatus returns the status of the child, or nil if it has none
+// or if it cannot be parsed.
+func getInclusionStatus(child *contourv1.HTTPProxy) *inclusionStatus {
+	value := child.Annotations[statusAnnotation]
+	if value == "" {
+		return nil
+	}
+	status := &inclusionStatus{}
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil
+	}
+	return status
+}
+
+// setInclusionStatus records the outcome of reconciling the child against
+// parentRef in its status annotation. The transition time is only updated
+// when the state changes.
+func (r *HTTPProxyReconciler) setInclusionStatus(child *contourv1.HTTPProxy, parentRef string, result childResult) error {
+	status := inclusionStatus{
+		State:              result.State,
+		Parent:             parentRef,
+		Prefix:             result.Prefix,
+		Reason:             result.Reason,
+		Message:            result.Message,
+		ObservedGeneration: child.Generation,
+		LastTransitionTime: v1.Now(),
+		FailedPrefixes:     result.Failures,
+	}
+	if current := getInclusionStatus(child); current != nil && current.State == status.State {
+		status.LastTransitionTime = current.LastTransitionTime
+	}
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	child.Annotations[statusAnnotation] = string(value)
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons for events emitted on child and parent HTTPProxy objects.
+const (
+	reasonAttached              = "Attached"
+	reasonDetached              = "Detached"
+	reasonIncludeConflict       = "IncludeConflict"
+	reasonDuplicatePrefix       = "DuplicatePrefix"
+	reasonInclusionNotAllowed   = "InclusionNotAllowed"
+	reasonInclusionRevoked      = "InclusionRevoked"
+	reasonInvalidParentRef      = "InvalidParentRef"
+	reasonParentNotFound        = "ParentNotFound"
+	reasonParentDeleted         = "ParentDeleted"
+	reasonNamespaceNotAllowed   = "NamespaceNotAllowed"
+	reasonChildNotAllowed       = "ChildNotAllowed"
+	reasonInvalidPolicy         = "InvalidPolicy"
+	reasonPrefixNotAllowed      = "PrefixNotAllowed"
+	reasonPrefixReserved        = "PrefixReserved"
+	reasonPrefixAssigned        = "PrefixAssigned"
+	reasonPrefixPatternMismatch = "PrefixPatternMismatch"
+	reasonChildLimitReached     = "ChildLimitReached"
+	reasonNamespaceLimitReached = "NamespaceLimitReached"
+	reasonApprovalRequired      = "ApprovalRequired"
+	reasonParentFrozen          = "ParentFrozen"
+	reasonInvalidHeaders        = "InvalidHeaders"
+	reasonInvalidPrefix         = "InvalidPrefix"
+	reasonOverlappingPrefix     = "OverlappingPrefix"
+	reasonRouteConflict         = "RouteConflict"
+
+	reasonChildAdded   = "ChildAdded"
+	reasonChildUpdated = "ChildUpdated"
+	reasonChildRemoved = "ChildRemoved"
+)
+
+// eventType returns the type of the event emitted on the child for result.
+func (res childResult) eventType() string {
+	switch {
+	case res.State == stateAttached && res.Reason == reasonAttached:
+		return corev1.EventTypeNormal
+	case res.State == stateDetached && res.Reason != reasonInclusionRevoked:
+		return corev1.EventTypeNormal
+	default:
+		return corev1.EventTypeWarning
+	}
+}
+
+// recordParentEvents emits events on the parent for the children added to,
+// updated in or removed from its includes. The include of a child with a
+// single prefix that changed is reported as updated.
+func (r *HTTPProxyReconciler) recordParentEvents(parent *contourv1.HTTPProxy, before, after []managedInclude) {
+	previous := make(map[client.ObjectKey][]managedInclude, len(before))
+	for _, record := range before {
+		key := client.ObjectKey{Namespace: record.Namespace, Name: record.Name}
+		previous[key] = append(previous[key], record)
+	}
+	current := make(map[client.ObjectKey]int, len(after))
+	for _, record := range after {
+		current[client.ObjectKey{Namespace: record.Namespace, Name: record.Name}]++
+	}
+	matched := make(map[includeRef]bool, len(before))
+	for _, record := range after {
+		key := client.ObjectKey{Namespace: record.Namespace, Name: record.Name}
+		old, ok := findRecord(previous[key], record.Prefix)
+		if !ok && len(previous[key]) == 1 && current[key] == 1 {
+			old, ok = previous[key][0], true
+		}
+		if ok {
+			matched[includeRef{key, old.Prefix}] = true
+		}
+		switch {
+		case !ok && record.ApprovedBy != "":
+			r.Recorder.Eventf(parent, corev1.EventTypeNormal, reasonChildAdded, "Included %s with %s, approved by %s", key, describeConditions(record.Prefix, record.Headers), record.ApprovedBy)
+		case !ok:
+			r.Recorder.Eventf(parent, corev1.EventTypeNormal, reasonChildAdded, "Included %s with %s", key, describeConditions(record.Prefix, record.Headers))
+		case describeConditions(old.Prefix, old.Headers) == describeConditions(record.Prefix, record.Headers):
+			// The conditions of the include did not change.
+		case len(old.Headers) == 0 && len(record.Headers) == 0:
+			r.Recorder.Eventf(parent, corev1.EventTypeNormal, reasonChildUpdated, "Updated include for %s from prefix %s to %s", key, old.Prefix, record.Prefix)
+		default:
+			r.Recorder.Eventf(parent, corev1.EventTypeNormal, reasonChildUpdated, "Updated include for %s from %s to %s", key, describeConditions(old.Prefix, old.Headers), describeConditions(record.Prefix, record.Headers))
+		}
+	}
+	for _, record := range before {
+		key := client.ObjectKey{Namespace: record.Namespace, Name: record.Name}
+		if !matched[includeRef{key, record.Prefix}] {
+			r.Recorder.Eventf(parent, corev1.EventTypeNormal, reasonChildRemoved, "Removed include for %s with %s", key, describeConditions(record.Prefix, record.Headers))
+		}
+	}
+}
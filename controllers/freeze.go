@@ -0,0 +1,242 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"golang.org/x/xerrors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	freezeAnnotation        = "oyako.atelierhsn.com/freeze"
+	freezeWindowsAnnotation = "oyako.atelierhsn.com/freeze-windows"
+)
+
+// FreezeWindow is a period of time during which parents are not modified.
+type FreezeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ParseFreezeWindows parses a comma-separated list of freeze windows, each
+// made of two RFC 3339 timestamps separated by a slash.
+func ParseFreezeWindows(value string) ([]FreezeWindow, error) {
+	var windows []FreezeWindow
+	for _, item := range splitList(value) {
+		bounds := strings.Split(item, "/")
+		if len(bounds) != 2 {
+			return nil, xerrors.Errorf("invalid freeze window %q, expected start/end", item)
+		}
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, xerrors.Errorf("invalid freeze window %q: %w", item, err)
+		}
+		end, err := time.Parse(time.RFC3339, strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return nil, xerrors.Errorf("invalid freeze window %q: %w", item, err)
+		}
+		if !end.After(start) {
+			return nil, xerrors.Errorf("invalid freeze window %q, end is not after start", item)
+		}
+		windows = append(windows, FreezeWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parentFreeze describes why a parent cannot be modified.
+type parentFreeze struct {
+	// Until is when the freeze ends, or zero if it only ends when the
+	// parent is updated.
+	Until   time.Time
+	Message string
+}
+
+// getParentFreeze returns the freeze in effect for the parent at now, either
+// from its annotations or from the freeze windows of the controller, or nil
+// if the parent may be modified. Parents with invalid freeze windows are
+// considered frozen until they are fixed.
+func (r *HTTPProxyReconciler) getParentFreeze(parent *contourv1.HTTPProxy, parentRef string, now time.Time) *parentFreeze {
+	if parent.Annotations[freezeAnnotation] == "true" {
+		return &parentFreeze{Message: fmt.Sprintf("Parent %s is frozen", parentRef)}
+	}
+	windows, err := ParseFreezeWindows(parent.Annotations[freezeWindowsAnnotation])
+	if err != nil {
+		return &parentFreeze{Message: fmt.Sprintf("Parent %s is frozen because of its %s annotation: %v", parentRef, freezeWindowsAnnotation, err)}
+	}
+	windows = append(windows, r.FreezeWindows...)
+	var until time.Time
+	// Adjacent or overlapping windows extend the freeze.
+	for extended := true; extended; {
+		extended = false
+		for _, window := range windows {
+			from := now
+			if !until.IsZero() {
+				from = until
+			}
+			if !window.Start.After(from) && window.End.After(from) {
+				until = window.End
+				extended = true
+			}
+		}
+	}
+	if until.IsZero() {
+		return nil
+	}
+	return &parentFreeze{
+		Until:   until,
+		Message: fmt.Sprintf("Parent %s is frozen until %s", parentRef, until.Format(time.RFC3339)),
+	}
+}
+
+// reconcileFrozenParent reports the outcome for the children of a frozen
+// parent without modifying it. Children whose include would be added,
+// updated or removed are kept as they are until the freeze ends. The only
+// exception are included children being deleted, whose includes are removed
+// so that their finalizer does not hold up their deletion for as long as the
+// freeze lasts.
+func (r *HTTPProxyReconciler) reconcileFrozenParent(ctx context.Context, parent *contourv1.HTTPProxy, parentRef string, children []*contourv1.HTTPProxy, policy *parentPolicy, freeze *parentFreeze) (ctrl.Result, error) {
+	deleted := deletedChildren(children, parentRef)
+	if len(deleted) != 0 {
+		var before, after []managedInclude
+		patched := parent
+		err := r.patchParentProxy(ctx, parent, func(parent *contourv1.HTTPProxy) error {
+			before, _ = r.getManagedIncludes(parent)
+			err := r.releaseDeletedChildren(parent, parentRef, deleted)
+			after, _ = r.getManagedIncludes(parent)
+			patched = parent
+			return err
+		})
+		if err != nil {
+			parentUpdateFailuresTotal.Inc()
+			return ctrl.Result{}, err
+		}
+		r.recordParentEvents(patched, before, after)
+		parent = patched
+	}
+
+	updated := parent.DeepCopy()
+	results, err := r.computeIncludes(updated, parentRef, children, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Malformed records are reported to children by computeIncludes.
+	before, _ := r.getManagedIncludes(parent)
+	after, _ := r.getManagedIncludes(updated)
+	headersBefore := recordedHeaders(before)
+	headersAfter := recordedHeaders(after)
+	for idx, child := range children {
+		result := results[idx]
+		key := client.ObjectKeyFromObject(child)
+		wasIncluded := child.Annotations[appliedParentAnnotation] == parentRef
+		included := result.State == stateAttached || result.State == stateFrozen
+		unchanged := child.Annotations[appliedPrefixAnnotation] == result.Prefix && headersBefore[key] == headersAfter[key]
+		if deleted[key] != nil || wasIncluded == included && (!included || unchanged) {
+			continue
+		}
+		deferred := childResult{
+			State:   statePending,
+			Reason:  reasonParentFrozen,
+			Message: fmt.Sprintf("%s, deferred: %s", freeze.Message, result.Message),
+		}
+		if wasIncluded {
+			deferred.State = stateFrozen
+			deferred.Prefix = child.Annotations[appliedPrefixAnnotation]
+		}
+		results[idx] = deferred
+	}
+	recordChildStates(parentRef, results)
+	for idx, child := range children {
+		if err := r.applyChildResult(ctx, child, parentRef, results[idx]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if freeze.Until.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(freeze.Until)}, nil
+}
+
+// deletedChildren returns the children being deleted that may be included
+// in the parent. Children predating the bookkeeping annotations are
+// recognized the same way as in isLegacyInclude.
+func deletedChildren(children []*contourv1.HTTPProxy, parentRef string) map[client.ObjectKey]*contourv1.HTTPProxy {
+	deleted := make(map[client.ObjectKey]*contourv1.HTTPProxy)
+	for _, child := range children {
+		if child.DeletionTimestamp.IsZero() {
+			continue
+		}
+		legacy := controllerutil.ContainsFinalizer(child, finalizerName) && child.Annotations[parentRefAnnotation] == parentRef
+		if child.Annotations[appliedParentAnnotation] == parentRef || legacy {
+			deleted[client.ObjectKeyFromObject(child)] = child
+		}
+	}
+	return deleted
+}
+
+// releaseDeletedChildren removes the includes managed by oyako for the
+// children being deleted from the parent, along with their records, and
+// leaves the rest of the parent as it is. Parents with malformed records are
+// left alone, as in computeIncludes.
+func (r *HTTPProxyReconciler) releaseDeletedChildren(parent *contourv1.HTTPProxy, parentRef string, deleted map[client.ObjectKey]*contourv1.HTTPProxy) error {
+	records, err := r.getManagedIncludes(parent)
+	if err != nil {
+		return nil
+	}
+	recordsByKey := make(map[client.ObjectKey][]managedInclude, len(records))
+	var kept []managedInclude
+	for _, record := range records {
+		key := client.ObjectKey{Namespace: record.Namespace, Name: record.Name}
+		if deleted[key] != nil {
+			recordsByKey[key] = append(recordsByKey[key], record)
+			continue
+		}
+		kept = append(kept, record)
+	}
+	var includes []contourv1.Include
+	for _, include := range parent.Spec.Includes {
+		key := includeKey(parent, include)
+		if child := deleted[key]; child != nil {
+			if _, ok := findRecord(recordsByKey[key], includePrefix(include)); ok || r.isLegacyInclude(child, parentRef, include) {
+				continue
+			}
+		}
+		includes = append(includes, include)
+	}
+	parent.Spec.Includes = includes
+	return r.setManagedIncludes(parent, kept)
+}
+
+// recordedHeaders returns the header conditions of each managed include
+// that has some.
+func recordedHeaders(records []managedInclude) map[client.ObjectKey]string {
+	headers := make(map[client.ObjectKey]string, len(records))
+	for _, record := range records {
+		if len(record.Headers) == 0 {
+			continue
+		}
+		headers[client.ObjectKey{Namespace: record.Namespace, Name: record.Name}] = describeConditions(record.Prefix, record.Headers)
+	}
+	return headers
+}
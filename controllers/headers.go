@@ -0,0 +1,186 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"golang.org/x/xerrors"
+)
+
+// headersAnnotation holds the header conditions a child is included with, as
+// a JSON list of Contour header match conditions, for instance
+// [{"name": "x-tenant", "exact": "acme"}].
+const headersAnnotation = "oyako.atelierhsn.com/headers"
+
+// childHeaders returns the header conditions the child is included with,
+// sorted so that equivalent sets of conditions compare equal.
+func childHeaders(h *contourv1.HTTPProxy) ([]contourv1.HeaderMatchCondition, error) {
+	value := h.Annotations[headersAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+	var headers []contourv1.HeaderMatchCondition
+	if err := json.Unmarshal([]byte(value), &headers); err != nil {
+		return nil, xerrors.Errorf("invalid %s annotation: %w", headersAnnotation, err)
+	}
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		if err := validateHeader(header); err != nil {
+			return nil, err
+		}
+		key := describeHeader(header)
+		if seen[key] {
+			return nil, xerrors.Errorf("duplicate header condition %s", key)
+		}
+		seen[key] = true
+	}
+	sortHeaders(headers)
+	return headers, nil
+}
+
+// validateHeader checks that a header condition names a header and sets
+// exactly one of the supported match types.
+func validateHeader(header contourv1.HeaderMatchCondition) error {
+	if header.Name == "" {
+		return xerrors.New("header condition without name")
+	}
+	if header.NotExact != "" || header.NotContains != "" {
+		return xerrors.Errorf("header condition on %s: only exact, contains, present and notpresent are supported", header.Name)
+	}
+	matches := 0
+	for _, set := range []bool{header.Exact != "", header.Contains != "", header.Present, header.NotPresent} {
+		if set {
+			matches++
+		}
+	}
+	if matches != 1 {
+		return xerrors.Errorf("header condition on %s must set exactly one of exact, contains, present or notpresent", header.Name)
+	}
+	return nil
+}
+
+func sortHeaders(headers []contourv1.HeaderMatchCondition) {
+	sort.Slice(headers, func(i, j int) bool {
+		return describeHeader(headers[i]) < describeHeader(headers[j])
+	})
+}
+
+// describeHeader returns a human-readable form of a header condition. Header
+// names are case-insensitive.
+func describeHeader(header contourv1.HeaderMatchCondition) string {
+	name := strings.ToLower(header.Name)
+	switch {
+	case header.Exact != "":
+		return fmt.Sprintf("%s exact %q", name, header.Exact)
+	case header.Contains != "":
+		return fmt.Sprintf("%s contains %q", name, header.Contains)
+	case header.NotExact != "":
+		return fmt.Sprintf("%s notexact %q", name, header.NotExact)
+	case header.NotContains != "":
+		return fmt.Sprintf("%s notcontains %q", name, header.NotContains)
+	case header.NotPresent:
+		return fmt.Sprintf("%s notpresent", name)
+	default:
+		return fmt.Sprintf("%s present", name)
+	}
+}
+
+// describeHeaders returns a human-readable form of a set of header
+// conditions, regardless of their order.
+func describeHeaders(headers []contourv1.HeaderMatchCondition) string {
+	described := make([]string, len(headers))
+	for idx, header := range headers {
+		described[idx] = describeHeader(header)
+	}
+	sort.Strings(described)
+	return strings.Join(described, ", ")
+}
+
+// describeConditions returns a human-readable form of the conditions of an
+// include, which also serves as the key for detecting duplicate includes.
+func describeConditions(prefix string, headers []contourv1.HeaderMatchCondition) string {
+	if len(headers) == 0 {
+		return fmt.Sprintf("prefix %s", prefix)
+	}
+	return fmt.Sprintf("prefix %s and headers %s", prefix, describeHeaders(headers))
+}
+
+// includeConditions returns the match conditions of an include with the
+// given prefix and header conditions.
+func includeConditions(prefix string, headers []contourv1.HeaderMatchCondition) []contourv1.MatchCondition {
+	conditions := []contourv1.MatchCondition{
+		{
+			Prefix: prefix,
+		},
+	}
+	for _, header := range headers {
+		header := header
+		conditions = append(conditions, contourv1.MatchCondition{
+			Header: &header,
+		})
+	}
+	return conditions
+}
+
+// includeHeaders returns the header conditions of an existing include.
+func includeHeaders(include contourv1.Include) []contourv1.HeaderMatchCondition {
+	var headers []contourv1.HeaderMatchCondition
+	for _, condition := range include.Conditions {
+		if condition.Header != nil {
+			headers = append(headers, *condition.Header)
+		}
+	}
+	return headers
+}
+
+// includeConditionsKey returns the key of the conditions of an existing
+// include, as returned by describeConditions, or an empty string if the
+// include has no prefix condition.
+func includeConditionsKey(include contourv1.Include) string {
+	prefix := includePrefix(include)
+	if prefix == "" {
+		return ""
+	}
+	return describeConditions(prefix, includeHeaders(include))
+}
+
+// routeConditions returns the normalized prefix and the header conditions of
+// a route of the parent. Routes without a prefix condition match every path.
+func routeConditions(route contourv1.Route) (string, []contourv1.HeaderMatchCondition) {
+	prefix := "/"
+	var headers []contourv1.HeaderMatchCondition
+	for _, condition := range route.Conditions {
+		if condition.Prefix != "" {
+			prefix = condition.Prefix
+		}
+		if condition.Header != nil {
+			headers = append(headers, *condition.Header)
+		}
+	}
+	return canonicalPrefix(prefix), headers
+}
+
+// routeConditionsKey returns the key of the conditions of a route of the
+// parent, as returned by describeConditions.
+func routeConditionsKey(route contourv1.Route) string {
+	return describeConditions(routeConditions(route))
+}
@@ -20,16 +20,24 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
 	"github.com/go-logr/logr"
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
 	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -37,189 +45,351 @@ const (
 	parentRefAnnotation      = "oyako.atelierhsn.com/parent"
 	pathPrefixAnnotation     = "oyako.atelierhsn.com/prefix"
 	finalizerName            = "oyako.atelierhsn.com/finalizer"
+	appliedParentAnnotation  = "oyako.atelierhsn.com/applied-parent"
+	appliedPrefixAnnotation  = "oyako.atelierhsn.com/applied-prefix"
+	statusAnnotation         = "oyako.atelierhsn.com/status"
+
+	parentRefIndex        = ".metadata.annotations.parent"
+	appliedParentRefIndex = ".metadata.annotations.applied-parent"
 )
 
+var errInvalidParentRef = xerrors.New("invalid parent")
+
 // HTTPProxyReconciler reconciles a HTTPProxy object.
 type HTTPProxyReconciler struct {
-	Client client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Client   client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// DefaultRevocationMode is the revocation mode applied to parents that do
+	// not specify one. Defaults to RevocationModeFreeze.
+	DefaultRevocationMode string
+
+	// FreezeWindows are the periods during which no parent is modified, in
+	// addition to those declared on each parent.
+	FreezeWindows []FreezeWindow
 }
 
 // +kubebuilder:rbac:groups=projectcontour.io,resources=httpproxies,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=projectcontour.io,resources=httpproxies/status,verbs=get
 // +kubebuilder:rbac:groups=projectcontour.io,resources=httpproxies/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile updates parent HTTPProxy objects.
+// Reconcile updates parent HTTPProxy objects. Requests are keyed by parent,
+// so that the includes for all of its children are computed and written at
+// once.
 func (r *HTTPProxyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("httpproxy", req.NamespacedName)
+	parentRef := fmt.Sprintf("%s/%s", req.Namespace, req.Name)
 
-	httpProxy := &contourv1.HTTPProxy{}
-	key := client.ObjectKey{
-		Namespace: req.Namespace,
-		Name:      req.Name,
+	children, err := r.listChildProxies(ctx, parentRef)
+	if err != nil {
+		log.Error(err, "unable to list child HTTPProxy")
+		return ctrl.Result{}, err
 	}
-	err := r.Client.Get(ctx, key, httpProxy)
+
+	parentProxy := &contourv1.HTTPProxy{}
+	err = r.Client.Get(ctx, req.NamespacedName, parentProxy)
 	if apierrors.IsNotFound(err) {
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, r.reconcileMissingParent(ctx, log, parentRef, children, reasonParentNotFound, "Parent %s not found")
 	}
 	if err != nil {
 		log.Error(err, "unable to get HTTPProxy")
 		return ctrl.Result{}, err
 	}
-	if httpProxy.Annotations[parentRefAnnotation] == "" {
-		return ctrl.Result{}, nil
+	if !parentProxy.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.reconcileMissingParent(ctx, log, parentRef, children, reasonParentDeleted, "Parent %s is being deleted")
 	}
-	if httpProxy.ObjectMeta.DeletionTimestamp.IsZero() {
-		if !r.hasFinalizer(httpProxy, finalizerName) {
-			controllerutil.AddFinalizer(httpProxy, finalizerName)
-			if err := r.Client.Update(ctx, httpProxy); err != nil {
-				return ctrl.Result{}, err
-			}
-		}
-	} else {
-		if r.hasFinalizer(httpProxy, finalizerName) {
-			if err := r.cleanupParentProxy(ctx, httpProxy, log); err != nil {
-				return ctrl.Result{}, err
-			}
-			controllerutil.RemoveFinalizer(httpProxy, finalizerName)
-			if err := r.Client.Update(ctx, httpProxy); err != nil {
-				return ctrl.Result{}, err
-			}
-		}
+	// Every HTTPProxy is also reconciled under its own key, which is where
+	// children with a malformed parent reference are caught.
+	if err := r.reconcileInvalidParentRef(ctx, parentProxy); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(children) == 0 && parentProxy.Annotations[managedIncludesAnnotation] == "" {
+		recordChildStates(parentRef, nil)
 		return ctrl.Result{}, nil
 	}
 
-	stop, err := r.reconcileParentProxy(ctx, httpProxy, log)
+	policy, err := r.getParentPolicy(ctx, parentProxy, children)
 	if err != nil {
-		log.Error(err, "failed to reconcile HTTPProxy")
+		log.Error(err, "unable to get inclusion policies")
+		return ctrl.Result{}, err
 	}
-	if stop {
-		return ctrl.Result{}, nil
+	if freeze := r.getParentFreeze(parentProxy, parentRef, time.Now()); freeze != nil {
+		log.Info("HTTPProxy parent frozen", "message", freeze.Message)
+		return r.reconcileFrozenParent(ctx, parentProxy, parentRef, children, policy, freeze)
 	}
 
-	return ctrl.Result{}, err
+	var results []childResult
+	var before, after []managedInclude
+	patched := parentProxy
+	err = r.patchParentProxy(ctx, parentProxy, func(parent *contourv1.HTTPProxy) error {
+		var err error
+		// Malformed records are reported to children by computeIncludes.
+		before, _ = r.getManagedIncludes(parent)
+		results, err = r.computeIncludes(parent, parentRef, children, policy)
+		after, _ = r.getManagedIncludes(parent)
+		patched = parent
+		return err
+	})
+	if err != nil {
+		parentUpdateFailuresTotal.Inc()
+		log.Error(err, "failed to update parent HTTPProxy")
+		return ctrl.Result{}, err
+	}
+	r.recordParentEvents(patched, before, after)
+	recordChildStates(parentRef, results)
+	allowed := policy.allowsInclusion(patched)
+	for idx, child := range children {
+		logRevocation(log, child, results[idx], allowed)
+		if err := r.applyChildResult(ctx, child, parentRef, results[idx]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	log.Info("HTTPProxy parent reconciled", "children", len(children))
+	return ctrl.Result{}, nil
 }
 
-func (r *HTTPProxyReconciler) hasFinalizer(h *contourv1.HTTPProxy, finalizer string) bool {
-	for _, f := range h.GetFinalizers() {
-		if f == finalizer {
-			return true
+// reconcileMissingParent handles the children of a parent that does not
+// exist or is being deleted. There is no include to update, so children
+// referencing the parent are left pending and the others are released.
+func (r *HTTPProxyReconciler) reconcileMissingParent(ctx context.Context, log logr.Logger, parentRef string, children []*contourv1.HTTPProxy, reason, messageFormat string) error {
+	message := fmt.Sprintf(messageFormat, parentRef)
+	logMessage := "parent HTTPProxy not found, no include to remove"
+	if reason == reasonParentDeleted {
+		logMessage = "parent HTTPProxy is being deleted, include removed along with it"
+	}
+	results := make([]childResult, 0, len(children))
+	defer func() { recordChildStates(parentRef, results) }()
+	for _, child := range children {
+		result := childResult{
+			State:   statePending,
+			Reason:  reason,
+			Message: message,
+		}
+		if !r.wantsParent(child, parentRef) {
+			result = r.releaseResult(child, parentRef)
+			result.Reason = reason
+			result.Message = fmt.Sprintf("%s, no include to remove", message)
+			log.Info(logMessage, "child", client.ObjectKeyFromObject(child))
+		}
+		results = append(results, result)
+		if err := r.applyChildResult(ctx, child, parentRef, result); err != nil {
+			return err
 		}
 	}
-	return false
+	return nil
 }
 
-func (r *HTTPProxyReconciler) getParentProxy(ctx context.Context, parentRef string) (parent *contourv1.HTTPProxy, err error) {
-	namespacedName := strings.Split(parentRef, "/")
-	if len(namespacedName) != 2 {
-		return nil, xerrors.Errorf("invalid parent %s", namespacedName)
+// logRevocation logs children released from a parent that no longer allows
+// child inclusions, along with what happened to their include. Children are
+// only logged when their state changes.
+func logRevocation(log logr.Logger, child *contourv1.HTTPProxy, result childResult, allowed bool) {
+	key := client.ObjectKeyFromObject(child)
+	if status := getInclusionStatus(child); status != nil && status.State == result.State && status.Reason == result.Reason {
+		return
 	}
-	key := client.ObjectKey{
-		Namespace: namespacedName[0],
-		Name:      namespacedName[1],
+	switch {
+	case result.Reason == reasonInclusionRevoked && result.State == stateDetached:
+		log.Info("parent HTTPProxy no longer allows child inclusions, include removed", "child", key)
+	case result.Reason == reasonInclusionRevoked && result.State == stateFrozen:
+		log.Info("parent HTTPProxy no longer allows child inclusions, include frozen in place", "child", key)
+	case result.Release && !allowed:
+		log.Info("parent HTTPProxy no longer allows child inclusions, removing include anyway", "child", key)
 	}
-	parent = &contourv1.HTTPProxy{}
-	err = r.Client.Get(ctx, key, parent)
-	return
 }
 
-func (r *HTTPProxyReconciler) isPrefixDuplicate(includes []contourv1.Include, childMeta v1.ObjectMeta, prefix string) bool {
-	for _, include := range includes {
-		if include.Namespace == childMeta.Namespace && include.Name == childMeta.Name {
-			continue
+// applyChildResult updates the finalizer and bookkeeping annotations of the
+// child according to the outcome of reconciling its parent.
+func (r *HTTPProxyReconciler) applyChildResult(ctx context.Context, child *contourv1.HTTPProxy, parentRef string, result childResult) error {
+	orig := child.DeepCopy()
+	if child.Annotations == nil {
+		child.Annotations = map[string]string{}
+	}
+	switch {
+	case result.State == stateAttached:
+		controllerutil.AddFinalizer(child, finalizerName)
+		child.Annotations[appliedParentAnnotation] = parentRef
+		child.Annotations[appliedPrefixAnnotation] = result.Prefix
+		if err := r.setInclusionStatus(child, parentRef, result); err != nil {
+			return err
+		}
+	case result.State == stateFrozen:
+		if err := r.setInclusionStatus(child, parentRef, result); err != nil {
+			return err
+		}
+	default:
+		if child.Annotations[appliedParentAnnotation] == parentRef {
+			delete(child.Annotations, appliedParentAnnotation)
+			delete(child.Annotations, appliedPrefixAnnotation)
 		}
-		for _, condition := range include.Conditions {
-			if condition.Prefix == prefix {
-				return true
+		switch {
+		case result.Release:
+			delete(child.Annotations, statusAnnotation)
+		case result.Reason == reasonDetached:
+			// The child moved to another parent, which now owns its status.
+		default:
+			if err := r.setInclusionStatus(child, parentRef, result); err != nil {
+				return err
 			}
 		}
-	}
-	return false
-}
-
-func (r *HTTPProxyReconciler) findIncludeRef(includes []contourv1.Include, childMeta v1.ObjectMeta) int {
-	for idx, include := range includes {
-		if include.Namespace == childMeta.Namespace && include.Name == childMeta.Name {
-			return idx
+		// The finalizer is only needed as long as the child is included in a
+		// parent.
+		if child.Annotations[appliedParentAnnotation] == "" {
+			controllerutil.RemoveFinalizer(child, finalizerName)
 		}
 	}
-	return -1
-}
-
-func (r *HTTPProxyReconciler) cleanupParentProxy(ctx context.Context, childProxy *contourv1.HTTPProxy, log logr.Logger) error {
-	parentRef := childProxy.Annotations[parentRefAnnotation]
-	parentProxy, err := r.getParentProxy(ctx, parentRef)
-	if err != nil {
+	if equality.Semantic.DeepEqual(orig, child) {
+		return nil
+	}
+	if err := r.Client.Update(ctx, child); err != nil {
 		return err
 	}
-	if parentProxy.Annotations[allowInclusionAnnotation] != "true" {
-		return xerrors.Errorf("parent %s does not allow child inclusions", parentRef)
+	if controllerutil.ContainsFinalizer(orig, finalizerName) && !controllerutil.ContainsFinalizer(child, finalizerName) {
+		finalizerCleanupsTotal.Inc()
 	}
-	includes := parentProxy.Spec.Includes
-	childIdx := r.findIncludeRef(includes, childProxy.ObjectMeta)
-	if childIdx < 0 {
-		return nil
+	if result.State == stateRejected {
+		rejectedInclusionsTotal.WithLabelValues(result.Reason).Inc()
 	}
-	includes = append(includes[:childIdx], includes[childIdx+1:]...)
-	parentProxy.Spec.Includes = includes
-	err = r.Client.Update(ctx, parentProxy, &client.UpdateOptions{
-		FieldManager: "oyako",
-	})
-	if err != nil {
-		return err
+	for _, failure := range result.Failures {
+		if failure.State == stateRejected {
+			rejectedInclusionsTotal.WithLabelValues(failure.Reason).Inc()
+		}
+	}
+	if result.Reason != "" {
+		r.Recorder.Event(child, result.eventType(), result.Reason, result.Message)
 	}
-	log.Info("cleaned up parent HTTPProxy")
 	return nil
 }
 
-func (r *HTTPProxyReconciler) reconcileParentProxy(ctx context.Context, childProxy *contourv1.HTTPProxy, log logr.Logger) (bool, error) {
-	parentRef := childProxy.Annotations[parentRefAnnotation]
-	parentProxy, err := r.getParentProxy(ctx, parentRef)
-	if err != nil {
-		return false, err
-	}
-	if parentProxy.Annotations[allowInclusionAnnotation] != "true" {
-		return true, xerrors.Errorf("parent %s does not allow child inclusions", parentRef)
-	}
-	prefix := childProxy.Annotations[pathPrefixAnnotation]
-	if prefix == "" {
-		prefix = fmt.Sprintf("/%s", childProxy.Name)
+// reconcileInvalidParentRef rejects the HTTPProxy if it holds a malformed
+// parent reference, since it cannot be matched to any parent.
+func (r *HTTPProxyReconciler) reconcileInvalidParentRef(ctx context.Context, h *contourv1.HTTPProxy) error {
+	parentRef := h.Annotations[parentRefAnnotation]
+	if parentRef == "" || !h.DeletionTimestamp.IsZero() {
+		return nil
 	}
-	includes := parentProxy.Spec.Includes
-	if r.isPrefixDuplicate(includes, childProxy.ObjectMeta, prefix) {
-		return true, xerrors.Errorf("duplicate prefix")
+	if _, err := parseParentRef(parentRef); err == nil {
+		return nil
 	}
-	prefixCondition := []contourv1.MatchCondition{
-		{
-			Prefix: prefix,
-		},
+	return r.applyChildResult(ctx, h, "", childResult{
+		State:   stateRejected,
+		Reason:  reasonInvalidParentRef,
+		Message: fmt.Sprintf("Invalid parent %q, expected namespace/name", parentRef),
+	})
+}
+
+// parseParentRef parses a parent reference in the namespace/name format.
+func parseParentRef(parentRef string) (client.ObjectKey, error) {
+	namespacedName := strings.Split(parentRef, "/")
+	if len(namespacedName) != 2 || namespacedName[0] == "" || namespacedName[1] == "" {
+		return client.ObjectKey{}, xerrors.Errorf("%w %s", errInvalidParentRef, parentRef)
 	}
+	return client.ObjectKey{
+		Namespace: namespacedName[0],
+		Name:      namespacedName[1],
+	}, nil
+}
 
-	childIdx := r.findIncludeRef(includes, childProxy.ObjectMeta)
-	if childIdx >= 0 {
-		parentProxy.Spec.Includes[childIdx].Conditions = prefixCondition
-	} else {
-		include := contourv1.Include{
-			Namespace:  childProxy.Namespace,
-			Name:       childProxy.Name,
-			Conditions: prefixCondition,
+// listChildProxies returns the HTTPProxy objects either referencing the
+// parent or last included in it.
+func (r *HTTPProxyReconciler) listChildProxies(ctx context.Context, parentRef string) ([]*contourv1.HTTPProxy, error) {
+	var children []*contourv1.HTTPProxy
+	seen := make(map[client.ObjectKey]bool)
+	for _, index := range []string{parentRefIndex, appliedParentRefIndex} {
+		list := &contourv1.HTTPProxyList{}
+		if err := r.Client.List(ctx, list, client.MatchingFields{index: parentRef}); err != nil {
+			return nil, err
+		}
+		for idx := range list.Items {
+			child := &list.Items[idx]
+			key := client.ObjectKeyFromObject(child)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			children = append(children, child)
 		}
-		parentProxy.Spec.Includes = append(parentProxy.Spec.Includes, include)
 	}
-	err = r.Client.Update(ctx, parentProxy, &client.UpdateOptions{
-		FieldManager: "oyako",
+	return children, nil
+}
+
+// patchParentProxy applies mutate to the parent and patches it with
+// optimistic locking. On conflicts, the latest parent is fetched and mutate
+// is applied again, so that concurrent edits to the parent are never
+// overwritten. Server-side apply is not an option since Contour declares
+// includes as an atomic list.
+func (r *HTTPProxyReconciler) patchParentProxy(ctx context.Context, parent *contourv1.HTTPProxy, mutate func(*contourv1.HTTPProxy) error) error {
+	key := client.ObjectKeyFromObject(parent)
+	latest := parent
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if latest == nil {
+			latest = &contourv1.HTTPProxy{}
+			if err := r.Client.Get(ctx, key, latest); err != nil {
+				return err
+			}
+		}
+		target := latest
+		latest = nil
+		orig := target.DeepCopy()
+		if err := mutate(target); err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(orig, target) {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})
+		err := r.Client.Patch(ctx, target, patch, client.FieldOwner("oyako"))
+		if apierrors.IsConflict(err) {
+			parentUpdateConflictsTotal.Inc()
+		}
+		return err
 	})
-	if err != nil {
-		return false, err
+}
+
+// parentRequestsForChild maps a child HTTPProxy to reconcile requests for
+// the parent it references and the parent it was last included in.
+func (r *HTTPProxyReconciler) parentRequestsForChild(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, annotation := range []string{parentRefAnnotation, appliedParentAnnotation} {
+		key, err := parseParentRef(obj.GetAnnotations()[annotation])
+		if err != nil {
+			continue
+		}
+		if len(requests) > 0 && requests[0].NamespacedName == key {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
+}
+
+// parentRefIndexer returns an indexer for the parent reference held in the
+// given annotation.
+func parentRefIndexer(annotation string) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		parentRef := obj.GetAnnotations()[annotation]
+		if _, err := parseParentRef(parentRef); err != nil {
+			return nil
+		}
+		return []string{parentRef}
 	}
-	log.Info("HTTPProxy parent reconciled")
-	return true, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HTTPProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(context.Background(), &contourv1.HTTPProxy{}, parentRefIndex, parentRefIndexer(parentRefAnnotation)); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(context.Background(), &contourv1.HTTPProxy{}, appliedParentRefIndex, parentRefIndexer(appliedParentAnnotation)); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&contourv1.HTTPProxy{}).
+		Watches(&source.Kind{Type: &contourv1.HTTPProxy{}}, handler.EnqueueRequestsFromMapFunc(r.parentRequestsForChild)).
+		Watches(&source.Kind{Type: &oyakov1alpha1.InclusionPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.parentRequestsForPolicy)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.parentRequestsForNamespace)).
 		Complete(r)
 }
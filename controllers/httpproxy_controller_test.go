@@ -7,6 +7,7 @@ import (
 	"math/big"
 	"time"
 
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
@@ -121,9 +122,10 @@ var _ = Describe("HTTPProxy controller", func() {
 		})
 		Expect(err).NotTo(HaveOccurred())
 		reconciler := &HTTPProxyReconciler{
-			Client: k8sManager.GetClient(),
-			Scheme: k8sManager.GetScheme(),
-			Log:    ctrl.Log.WithName("controllers").WithName("HTTPProxy"),
+			Client:   k8sManager.GetClient(),
+			Scheme:   k8sManager.GetScheme(),
+			Log:      ctrl.Log.WithName("controllers").WithName("HTTPProxy"),
+			Recorder: k8sManager.GetEventRecorderFor("oyako"),
 		}
 		Expect(reconciler.SetupWithManager(k8sManager)).To(Succeed())
 
@@ -238,6 +240,52 @@ var _ = Describe("HTTPProxy controller", func() {
 			}).Should(Succeed())
 		})
 
+		It("Should detach from the previous parent when the parent changes", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+			newParentName := fmt.Sprintf("%s-%s", TestParentNamespacePrefix, randomSuffix())
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parents")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+			newParent := parentProxyFromTemplate(parentNamespace, newParentName)
+			Expect(k8sClient.Create(ctx, newParent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("updating parent reference")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: childNamespace,
+				Name:      childName,
+			}, child)).To(Succeed())
+			child.Annotations[parentRefAnnotation] = fmt.Sprintf("%s/%s", parentNamespace, newParentName)
+			Expect(k8sClient.Update(ctx, child)).To(Succeed())
+
+			By("getting parents")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, newParentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+		})
+
 		It("Should not overwrite the parent's other includes", func() {
 			By("creating namespaces")
 			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
@@ -277,6 +325,123 @@ var _ = Describe("HTTPProxy controller", func() {
 			}).Should(Succeed())
 		})
 
+		It("Should record provenance of managed includes", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			}, parent)).To(Succeed())
+			reconciler := &HTTPProxyReconciler{}
+			records, err := reconciler.getManagedIncludes(parent)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].UID).To(Equal(child.UID))
+			Expect(records[0].Prefix).To(Equal(prefix))
+		})
+
+		It("Should not modify includes not managed by oyako", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent with a manual include")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Spec.Includes = []contourv1.Include{
+				{
+					Namespace: childNamespace,
+					Name:      childName,
+					Conditions: []contourv1.MatchCondition{
+						{
+							Prefix: "/manual",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Consistently(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+			Expect(parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, "/manual")).To(Succeed())
+
+			By("deleting child")
+			Expect(k8sClient.Delete(ctx, child)).To(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{
+					Namespace: childNamespace,
+					Name:      childName,
+				}, child)
+			}).ShouldNot(Succeed())
+			Expect(parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, "/manual")).To(Succeed())
+		})
+
+		It("Should include many children created at once", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, _, _ := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating children")
+			childNames := make([]string, 10)
+			for i := range childNames {
+				childNames[i] = fmt.Sprintf("%s-%d", TestChildNamespacePrefix, i)
+				child := childProxyFromTemplate(childNamespace, childNames[i], fmt.Sprintf("%s/%s", parentNamespace, parentName), "")
+				Expect(k8sClient.Create(ctx, child)).To(Succeed())
+			}
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			for _, childName := range childNames {
+				prefix := fmt.Sprintf("/%s", childName)
+				Eventually(func() error {
+					return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+				}).Should(Succeed())
+			}
+		})
+
 		It("Should not allow duplicate prefixes", func() {
 			By("creating namespaces")
 			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
@@ -378,6 +543,337 @@ var _ = Describe("HTTPProxy controller", func() {
 		})
 	})
 
+	Context("When creating or updating parent HTTPProxy", func() {
+		It("Should include children created before the parent", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("creating parent")
+			time.Sleep(time.Second)
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("getting parent")
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+		})
+
+		It("Should include children once inclusion is allowed", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent without annotation")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Annotations = map[string]string{}
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("allowing inclusion")
+			time.Sleep(time.Second)
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			}, parent)).To(Succeed())
+			parent.Annotations[allowInclusionAnnotation] = "true"
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("getting parent")
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+		})
+	})
+
+	Context("When an InclusionPolicy selects parent HTTPProxy", func() {
+		It("Should include children allowed by the policy", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+			otherNamespace := fmt.Sprintf("%s-%s", TestChildNamespacePrefix, randomSuffix())
+
+			for _, namespace := range []string{parentNamespace, childNamespace, otherNamespace} {
+				Expect(k8sClient.Create(ctx, &corev1.Namespace{
+					ObjectMeta: v1.ObjectMeta{Name: namespace},
+				})).To(Succeed())
+			}
+
+			By("creating policy")
+			policy := &oyakov1alpha1.InclusionPolicy{
+				ObjectMeta: v1.ObjectMeta{Name: parentName},
+				Spec: oyakov1alpha1.InclusionPolicySpec{
+					ParentSelector: &v1.LabelSelector{
+						MatchLabels: map[string]string{"policy": parentName},
+					},
+					AllowedNamespaces: []string{childNamespace},
+				},
+			}
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			}()
+
+			By("creating parent without annotation")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Annotations = map[string]string{}
+			parent.Labels = map[string]string{"policy": parentName}
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating children")
+			parentRef := fmt.Sprintf("%s/%s", parentNamespace, parentName)
+			child := childProxyFromTemplate(childNamespace, childName, parentRef, prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+			other := childProxyFromTemplate(otherNamespace, childName, parentRef, "")
+			Expect(k8sClient.Create(ctx, other)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+			Eventually(func() *inclusionStatus {
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(other), other)).To(Succeed())
+				return getInclusionStatus(other)
+			}).Should(HaveField("Reason", reasonNamespaceNotAllowed))
+		})
+	})
+
+	Context("When updating the labels of a child namespace", func() {
+		It("Should include children once their namespace is allowed", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			namespace := &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			}
+			Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+
+			By("creating parent with a namespace selector")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Annotations[allowedNamespaceSelectorAnnotation] = fmt.Sprintf("team=%s", parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+			Eventually(func() *inclusionStatus {
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(child), child)).To(Succeed())
+				return getInclusionStatus(child)
+			}).Should(HaveField("Reason", reasonNamespaceNotAllowed))
+
+			By("labelling the child namespace")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(namespace), namespace)).To(Succeed())
+			namespace.Labels = map[string]string{"team": parentName}
+			Expect(k8sClient.Update(ctx, namespace)).To(Succeed())
+
+			By("getting parent")
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("removing the label")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(namespace), namespace)).To(Succeed())
+			namespace.Labels = nil
+			Expect(k8sClient.Update(ctx, namespace)).To(Succeed())
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+		})
+	})
+
+	Context("When parent HTTPProxy is frozen", func() {
+		It("Should defer changes until the parent is unfrozen", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating frozen parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Annotations[freezeAnnotation] = "true"
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting child status")
+			time.Sleep(time.Second)
+			Eventually(func() *inclusionStatus {
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(child), child)).To(Succeed())
+				return getInclusionStatus(child)
+			}).Should(HaveField("Reason", reasonParentFrozen))
+			Expect(parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)).NotTo(Succeed())
+
+			By("unfreezing parent")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(parent), parent)).To(Succeed())
+			delete(parent.Annotations, freezeAnnotation)
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("getting parent")
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+		})
+
+		It("Should let included children be deleted", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("freezing parent")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(parent), parent)).To(Succeed())
+			parent.Annotations[freezeAnnotation] = "true"
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("deleting child")
+			Expect(k8sClient.Delete(ctx, child)).To(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKeyFromObject(child), child)
+			}).ShouldNot(Succeed())
+			Expect(parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)).NotTo(Succeed())
+		})
+	})
+
+	Context("When revoking inclusion on parent HTTPProxy", func() {
+		It("Should freeze included children by default", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("disallowing inclusion")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			}, parent)).To(Succeed())
+			parent.Annotations[allowInclusionAnnotation] = "false"
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("getting child")
+			Eventually(func() string {
+				Expect(k8sClient.Get(ctx, client.ObjectKey{
+					Namespace: childNamespace,
+					Name:      childName,
+				}, child)).To(Succeed())
+				return child.Annotations[statusAnnotation]
+			}).Should(ContainSubstring(`"state":"Frozen"`))
+			Expect(parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)).To(Succeed())
+		})
+
+		It("Should detach included children in detach mode", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			parent.Annotations[revocationModeAnnotation] = RevocationModeDetach
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("disallowing inclusion")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			}, parent)).To(Succeed())
+			parent.Annotations[allowInclusionAnnotation] = "false"
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("getting parent")
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: childNamespace,
+				Name:      childName,
+			}, child)).To(Succeed())
+			Expect(child.Annotations[statusAnnotation]).To(ContainSubstring(`"state":"Detached"`))
+		})
+	})
+
 	Context("When deleting child HTTPProxy", func() {
 		It("Should cleanup parent upon child's deletion", func() {
 			By("creating namespaces")
@@ -423,5 +919,150 @@ var _ = Describe("HTTPProxy controller", func() {
 				}, child)
 			}).ShouldNot(Succeed())
 		})
+
+		It("Should cleanup parent when the parent reference is removed", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("removing parent reference")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: childNamespace,
+				Name:      childName,
+			}, child)).To(Succeed())
+			delete(child.Annotations, parentRefAnnotation)
+			Expect(k8sClient.Update(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+			Eventually(func() []string {
+				Expect(k8sClient.Get(ctx, client.ObjectKey{
+					Namespace: childNamespace,
+					Name:      childName,
+				}, child)).To(Succeed())
+				return child.Finalizers
+			}).ShouldNot(ContainElement(finalizerName))
+		})
+
+		It("Should finish deletion when the parent is gone", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("deleting parent")
+			Expect(k8sClient.Delete(ctx, parent)).To(Succeed())
+
+			By("deleting child")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: childNamespace,
+				Name:      childName,
+			}, child)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, child)).To(Succeed())
+
+			By("getting child")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{
+					Namespace: childNamespace,
+					Name:      childName,
+				}, child)
+			}).ShouldNot(Succeed())
+		})
+
+		It("Should finish deletion when the parent no longer allows inclusion", func() {
+			By("creating namespaces")
+			parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+			})).To(Succeed())
+
+			By("creating parent")
+			parent := parentProxyFromTemplate(parentNamespace, parentName)
+			Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+			By("creating child")
+			child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+			Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+			By("getting parent")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).Should(Succeed())
+
+			By("disallowing inclusion")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			}, parent)).To(Succeed())
+			parent.Annotations[allowInclusionAnnotation] = "false"
+			Expect(k8sClient.Update(ctx, parent)).To(Succeed())
+
+			By("deleting child")
+			Expect(k8sClient.Get(ctx, client.ObjectKey{
+				Namespace: childNamespace,
+				Name:      childName,
+			}, child)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, child)).To(Succeed())
+
+			By("getting child")
+			time.Sleep(time.Second)
+			Eventually(func() error {
+				return k8sClient.Get(ctx, client.ObjectKey{
+					Namespace: childNamespace,
+					Name:      childName,
+				}, child)
+			}).ShouldNot(Succeed())
+			Eventually(func() error {
+				return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+			}).ShouldNot(Succeed())
+		})
 	})
 })
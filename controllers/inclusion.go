@@ -0,0 +1,529 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	stateAttached = "Attached"
+	statePending  = "Pending"
+	stateRejected = "Rejected"
+	stateConflict = "Conflict"
+	stateFrozen   = "Frozen"
+	stateDetached = "Detached"
+)
+
+// childResult is the outcome of reconciling a child against its parent.
+type childResult struct {
+	State   string
+	Prefix  string
+	Reason  string
+	Message string
+	// Release is set when the child is being deleted or does not reference
+	// any parent anymore, in which case its status is cleared.
+	Release bool
+	// Failures holds the outcome for each prefix of an attached child that
+	// could not be included.
+	Failures []prefixFailure
+}
+
+// prefixFailure is the outcome for a prefix of an attached child that could
+// not be included.
+type prefixFailure struct {
+	Prefix string `json:"prefix"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// wantsParent reports whether the child should be included in the parent.
+func (r *HTTPProxyReconciler) wantsParent(child *contourv1.HTTPProxy, parentRef string) bool {
+	return child.DeletionTimestamp.IsZero() && child.Annotations[parentRefAnnotation] == parentRef
+}
+
+// releaseResult returns the outcome for a child that no longer wants to be
+// included in the parent. Children that moved to another parent keep their
+// status, which is then handled by the new parent.
+func (r *HTTPProxyReconciler) releaseResult(child *contourv1.HTTPProxy, parentRef string) childResult {
+	_, err := parseParentRef(child.Annotations[parentRefAnnotation])
+	return childResult{
+		State:   stateDetached,
+		Reason:  reasonDetached,
+		Message: fmt.Sprintf("Removed from parent %s", parentRef),
+		Release: !child.DeletionTimestamp.IsZero() || err != nil,
+	}
+}
+
+// childPrefixes returns the prefixes under which the child is included,
+// given as a comma-separated list in the prefix annotation.
+func (r *HTTPProxyReconciler) childPrefixes(h *contourv1.HTTPProxy) []string {
+	var prefixes []string
+	for _, prefix := range splitList(h.Annotations[pathPrefixAnnotation]) {
+		if !containsString(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{fmt.Sprintf("/%s", h.Name)}
+	}
+	return prefixes
+}
+
+// includeRef identifies the include of a child under one of its prefixes.
+type includeRef struct {
+	client.ObjectKey
+	Prefix string
+}
+
+// includePrefix returns the normalized prefix condition of an include, if
+// any, so that includes written by hand compare equal to the prefixes
+// claimed by children.
+func includePrefix(include contourv1.Include) string {
+	for _, condition := range include.Conditions {
+		if condition.Prefix != "" {
+			return canonicalPrefix(condition.Prefix)
+		}
+	}
+	return ""
+}
+
+// includeKey returns the namespaced name of the HTTPProxy an include points
+// to.
+func includeKey(parent *contourv1.HTTPProxy, include contourv1.Include) client.ObjectKey {
+	namespace := include.Namespace
+	if namespace == "" {
+		namespace = parent.Namespace
+	}
+	return client.ObjectKey{Namespace: namespace, Name: include.Name}
+}
+
+// sortCandidates orders the children competing for inclusion in a parent.
+// Conditions already included for a child are kept by that child, see
+// computeIncludes. When several children newly claim the same conditions,
+// the first one in this order wins: the oldest child, then the first one by
+// namespace/name. This only depends on the children themselves, so that the
+// winner never changes across reconciliations or controller restarts.
+func sortCandidates(candidates []*contourv1.HTTPProxy) {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return client.ObjectKeyFromObject(a).String() < client.ObjectKeyFromObject(b).String()
+	})
+}
+
+// mergePrefixResults combines the outcomes for each of the prefixes of a
+// child. The child is attached as long as one of its prefixes is included,
+// and the outcome for every prefix is given in the message. The prefixes
+// that could not be included are listed in the failures of the result, the
+// first one giving its reason to the result.
+func mergePrefixResults(prefixes []string, results []childResult) childResult {
+	if len(results) == 1 {
+		return results[0]
+	}
+	merged := results[0]
+	var included, messages []string
+	var failures []prefixFailure
+	reason := reasonAttached
+	for idx, result := range results {
+		if result.State == stateAttached {
+			included = append(included, result.Prefix)
+			if reason == reasonAttached {
+				reason = result.Reason
+			}
+		} else {
+			failures = append(failures, prefixFailure{
+				Prefix: prefixes[idx],
+				State:  result.State,
+				Reason: result.Reason,
+			})
+		}
+		messages = append(messages, result.Message)
+	}
+	if len(included) != 0 {
+		merged = childResult{
+			State:    stateAttached,
+			Prefix:   strings.Join(included, ","),
+			Reason:   reason,
+			Failures: failures,
+		}
+		if len(failures) != 0 {
+			merged.Reason = failures[0].Reason
+		}
+	}
+	merged.Message = strings.Join(messages, "; ")
+	return merged
+}
+
+// computeIncludes updates the includes of the parent to match all of its
+// children allowed by policy, and returns the outcome for each child in the
+// order of children. Each prefix of a child gets its own include. Only
+// includes managed by oyako are ever modified or removed.
+func (r *HTTPProxyReconciler) computeIncludes(parent *contourv1.HTTPProxy, parentRef string, children []*contourv1.HTTPProxy, policy *parentPolicy) ([]childResult, error) {
+	results := make([]childResult, len(children))
+	positions := make(map[client.ObjectKey]int, len(children))
+	var candidates []*contourv1.HTTPProxy
+	for idx, child := range children {
+		positions[client.ObjectKeyFromObject(child)] = idx
+		if r.wantsParent(child, parentRef) {
+			candidates = append(candidates, child)
+		} else {
+			results[idx] = r.releaseResult(child, parentRef)
+		}
+	}
+
+	records, err := r.getManagedIncludes(parent)
+	if err != nil {
+		message := fmt.Sprintf("Unable to determine includes managed by oyako in parent %s: %v", parentRef, err)
+		for _, child := range candidates {
+			results[positions[client.ObjectKeyFromObject(child)]] = childResult{
+				State:   stateConflict,
+				Reason:  reasonIncludeConflict,
+				Message: message,
+			}
+		}
+		return results, nil
+	}
+	recordsByKey := make(map[client.ObjectKey][]managedInclude, len(records))
+	for _, record := range records {
+		key := client.ObjectKey{Namespace: record.Namespace, Name: record.Name}
+		recordsByKey[key] = append(recordsByKey[key], record)
+	}
+
+	// Split the current includes into those managed by oyako and hand-written
+	// ones, whose conditions can never be claimed by children. Includes are
+	// only duplicates when their prefix and header conditions all match.
+	// Each include is managed on its own, so that a hand-written include
+	// pointing to a child included by oyako under another prefix is kept.
+	managed := make(map[client.ObjectKey]bool)
+	managedRefs := make(map[includeRef]bool)
+	unmanaged := make(map[client.ObjectKey]bool)
+	claimed := make(map[string]client.ObjectKey)
+	legacy := make(map[includeRef]bool)
+	var claims []prefixClaim
+	for _, include := range parent.Spec.Includes {
+		key := includeKey(parent, include)
+		ref := includeRef{key, includePrefix(include)}
+		if _, ok := findRecord(recordsByKey[key], ref.Prefix); ok {
+			managed[key] = true
+			managedRefs[ref] = true
+			continue
+		}
+		if idx, ok := positions[key]; ok && r.isLegacyInclude(children[idx], parentRef, include) {
+			managed[key] = true
+			managedRefs[ref] = true
+			legacy[ref] = true
+			continue
+		}
+		unmanaged[key] = true
+		if conditions := includeConditionsKey(include); conditions != "" {
+			claimed[conditions] = key
+			claims = append(claims, prefixClaim{
+				Prefix:  includePrefix(include),
+				Headers: describeHeaders(includeHeaders(include)),
+				Owner:   key,
+			})
+		}
+	}
+	for key := range recordsByKey {
+		managed[key] = true
+	}
+	// Conditions already included for a child that still claims them stay
+	// with that child, so that a newly claimed prefix never displaces a live
+	// include, regardless of which child is older.
+	for _, child := range candidates {
+		key := client.ObjectKeyFromObject(child)
+		headers, err := childHeaders(child)
+		if err != nil {
+			continue
+		}
+		for _, raw := range r.childPrefixes(child) {
+			prefix, err := normalizePrefix(raw)
+			if err != nil {
+				continue
+			}
+			record, ok := findRecord(recordsByKey[key], prefix)
+			if ok && describeHeaders(record.Headers) != describeHeaders(headers) || !ok && !legacy[includeRef{key, prefix}] {
+				continue
+			}
+			conditions := describeConditions(prefix, headers)
+			if _, taken := claimed[conditions]; taken {
+				continue
+			}
+			claimed[conditions] = key
+			claims = append(claims, prefixClaim{
+				Prefix:  prefix,
+				Headers: describeHeaders(headers),
+				Owner:   key,
+				Kept:    true,
+			})
+		}
+	}
+	// The conditions of the parent's own routes can never be claimed either,
+	// since the routing would then be ambiguous. Prefixes overlapping with
+	// those of the routes are subject to the overlap policy, and are at least
+	// warned about.
+	routes := make(map[string]bool, len(parent.Spec.Routes))
+	for _, route := range parent.Spec.Routes {
+		routes[routeConditionsKey(route)] = true
+	}
+	parentRoutes := routeClaims(parent)
+
+	allowed := policy.allowsInclusion(parent)
+	limit, limitSource := policy.maxChildren()
+	namespaceLimit, namespaceLimitSource := policy.maxPrefixesPerNamespace()
+	namespacePrefixes := make(map[string]int)
+	requireApproval := policy.requiresApproval()
+	overlapPolicy, overlapSource := policy.overlapPolicy()
+	approvals := parseApprovals(parent.Annotations[approvedInclusionsAnnotation])
+	approvedBy := make(map[includeRef]string)
+	mode := r.revocationMode(parent)
+	frozen := make(map[client.ObjectKey]bool)
+	accepted := make(map[client.ObjectKey][]string)
+	acceptedHeaders := make(map[client.ObjectKey][]contourv1.HeaderMatchCondition)
+	sortCandidates(candidates)
+	for _, child := range candidates {
+		key := client.ObjectKeyFromObject(child)
+		idx := positions[key]
+		headers, headersErr := childHeaders(child)
+		status := getInclusionStatus(child)
+		switch {
+		case !allowed && managed[key] && mode == RevocationModeFreeze:
+			frozen[key] = true
+			results[idx] = childResult{
+				State:   stateFrozen,
+				Prefix:  recordedPrefixes(recordsByKey[key]),
+				Reason:  reasonInclusionRevoked,
+				Message: fmt.Sprintf("Parent %s no longer allows child inclusions, include frozen in place", parentRef),
+			}
+		case !allowed && (managed[key] || status != nil && status.State == stateDetached && status.Parent == parentRef):
+			results[idx] = childResult{
+				State:   stateDetached,
+				Reason:  reasonInclusionRevoked,
+				Message: fmt.Sprintf("Parent %s no longer allows child inclusions, detached", parentRef),
+			}
+		case !allowed:
+			results[idx] = childResult{
+				State:   stateRejected,
+				Reason:  reasonInclusionNotAllowed,
+				Message: fmt.Sprintf("Parent %s does not allow child inclusions", parentRef),
+			}
+		case unmanaged[key]:
+			results[idx] = childResult{
+				State:   stateConflict,
+				Reason:  reasonIncludeConflict,
+				Message: fmt.Sprintf("Parent %s already has an include for this HTTPProxy that is not managed by oyako", parentRef),
+			}
+		case headersErr != nil:
+			results[idx] = childResult{
+				State:   stateRejected,
+				Reason:  reasonInvalidHeaders,
+				Message: fmt.Sprintf("Invalid header conditions: %v", headersErr),
+			}
+		default:
+			var prefixes []string
+			var prefixResults []childResult
+			normalized := make(map[string]bool)
+			for _, raw := range r.childPrefixes(child) {
+				// Malformed prefixes are never written to the parent, which
+				// Contour would otherwise mark as invalid.
+				prefix, prefixErr := normalizePrefix(raw)
+				if prefixErr == nil && normalized[prefix] {
+					continue
+				}
+				normalized[prefix] = true
+				conditions := describeConditions(prefix, headers)
+				// Overlaps between siblings are only looked for when the
+				// policy cares about them, since this compares every pair of
+				// prefixes.
+				var overlap *prefixClaim
+				if overlapPolicy != overlapPolicyAllow {
+					overlap = overlappingClaim(claims, key, prefix, describeHeaders(headers), claimed[conditions] == key)
+				}
+				routeOverlap := overlappingClaim(parentRoutes, key, prefix, describeHeaders(headers), false)
+				rejection, admitted := policy.admit(child, parentRef, prefix)
+				// Includes already in place do not need to be approved again.
+				approver, approved := approvals[approvalKey(key, prefix)]
+				if record, ok := findRecord(recordsByKey[key], prefix); ok {
+					approver, approved = record.ApprovedBy, true
+				} else if legacy[includeRef{key, prefix}] {
+					approved = true
+				}
+				var result childResult
+				switch {
+				case prefixErr != nil:
+					result = childResult{
+						State:   stateRejected,
+						Reason:  reasonInvalidPrefix,
+						Message: fmt.Sprintf("Invalid prefix %q: %v", raw, prefixErr),
+					}
+				case !admitted:
+					result = rejection
+				case routes[conditions]:
+					result = childResult{
+						State:   stateConflict,
+						Reason:  reasonRouteConflict,
+						Message: fmt.Sprintf("Include with %s in parent %s collides with a route of the parent", conditions, parentRef),
+					}
+				case routeOverlap != nil && overlapPolicy == overlapPolicyDeny:
+					result = childResult{
+						State:   stateConflict,
+						Reason:  reasonRouteConflict,
+						Message: fmt.Sprintf("Prefix %s in parent %s overlaps with prefix %s of a route of the parent, per %s", prefix, parentRef, routeOverlap.Prefix, overlapSource),
+					}
+				case claimed[conditions] != client.ObjectKey{} && claimed[conditions] != key:
+					owner := claimed[conditions]
+					message := fmt.Sprintf("Include with %s in parent %s is claimed by %s", conditions, parentRef, owner)
+					if unmanaged[owner] {
+						message = fmt.Sprintf("Include with %s in parent %s is added by hand for %s", conditions, parentRef, owner)
+					}
+					result = childResult{
+						State:   stateConflict,
+						Reason:  reasonDuplicatePrefix,
+						Message: message,
+					}
+				case overlap != nil && overlapPolicy == overlapPolicyDeny:
+					result = childResult{
+						State:   stateConflict,
+						Reason:  reasonOverlappingPrefix,
+						Message: fmt.Sprintf("Prefix %s in parent %s overlaps with prefix %s of %s, per %s", prefix, parentRef, overlap.Prefix, overlap.Owner, overlapSource),
+					}
+				case limit >= 0 && len(accepted[key]) == 0 && len(accepted) >= limit:
+					result = childResult{
+						State:   stateRejected,
+						Reason:  reasonChildLimitReached,
+						Message: fmt.Sprintf("Parent %s already includes %d children, per %s", parentRef, limit, limitSource),
+					}
+				case namespaceLimit >= 0 && namespacePrefixes[child.Namespace] >= namespaceLimit:
+					result = childResult{
+						State:   stateRejected,
+						Reason:  reasonNamespaceLimitReached,
+						Message: fmt.Sprintf("Namespace %s already claims %d prefixes in parent %s, per %s", child.Namespace, namespaceLimit, parentRef, namespaceLimitSource),
+					}
+				case requireApproval && !approved:
+					result = childResult{
+						State:   statePending,
+						Reason:  reasonApprovalRequired,
+						Message: fmt.Sprintf("Waiting for %s to be approved in parent %s", approvalKey(key, prefix), parentRef),
+					}
+				default:
+					claimed[conditions] = key
+					claims = append(claims, prefixClaim{
+						Prefix:  prefix,
+						Headers: describeHeaders(headers),
+						Owner:   key,
+					})
+					accepted[key] = append(accepted[key], prefix)
+					acceptedHeaders[key] = headers
+					approvedBy[includeRef{key, prefix}] = approver
+					namespacePrefixes[child.Namespace]++
+					message := fmt.Sprintf("Included in parent %s with %s", parentRef, conditions)
+					if approver != "" {
+						message = fmt.Sprintf("%s, approved by %s", message, approver)
+					}
+					result = childResult{
+						State:   stateAttached,
+						Prefix:  prefix,
+						Reason:  reasonAttached,
+						Message: message,
+					}
+					switch {
+					case overlap != nil && overlapPolicy == overlapPolicyWarn:
+						result.Reason = reasonOverlappingPrefix
+						result.Message = fmt.Sprintf("%s, overlapping with prefix %s of %s", message, overlap.Prefix, overlap.Owner)
+					case routeOverlap != nil:
+						result.Reason = reasonOverlappingPrefix
+						result.Message = fmt.Sprintf("%s, overlapping with prefix %s of a route of the parent", message, routeOverlap.Prefix)
+					}
+				}
+				if prefixErr != nil {
+					prefix = raw
+				}
+				prefixes = append(prefixes, prefix)
+				prefixResults = append(prefixResults, result)
+			}
+			results[idx] = mergePrefixResults(prefixes, prefixResults)
+		}
+	}
+
+	// Rebuild the includes, keeping hand-written and frozen ones as-is and
+	// existing managed ones in place.
+	var includes []contourv1.Include
+	var newRecords []managedInclude
+	for _, record := range records {
+		if frozen[client.ObjectKey{Namespace: record.Namespace, Name: record.Name}] {
+			newRecords = append(newRecords, record)
+		}
+	}
+	included := make(map[includeRef]bool)
+	appendInclude := func(ref includeRef, include contourv1.Include) {
+		includes = append(includes, include)
+		included[ref] = true
+		child := children[positions[ref.ObjectKey]]
+		addedAt := v1.Now()
+		if record, ok := findRecord(recordsByKey[ref.ObjectKey], ref.Prefix); ok {
+			addedAt = record.AddedAt
+		}
+		newRecords = append(newRecords, managedInclude{
+			Namespace:  child.Namespace,
+			Name:       child.Name,
+			UID:        child.UID,
+			Parent:     parentRef,
+			Prefix:     ref.Prefix,
+			Headers:    acceptedHeaders[ref.ObjectKey],
+			AddedAt:    addedAt,
+			ApprovedBy: approvedBy[ref],
+		})
+	}
+	for _, include := range parent.Spec.Includes {
+		key := includeKey(parent, include)
+		ref := includeRef{key, includePrefix(include)}
+		switch {
+		case !managedRefs[ref] || frozen[key]:
+			includes = append(includes, include)
+		case included[ref]:
+			continue
+		case containsString(accepted[key], ref.Prefix):
+			include.Conditions = includeConditions(ref.Prefix, acceptedHeaders[key])
+			appendInclude(ref, include)
+		}
+	}
+	for _, child := range candidates {
+		key := client.ObjectKeyFromObject(child)
+		for _, prefix := range accepted[key] {
+			ref := includeRef{key, prefix}
+			if included[ref] {
+				continue
+			}
+			appendInclude(ref, contourv1.Include{
+				Namespace:  child.Namespace,
+				Name:       child.Name,
+				Conditions: includeConditions(prefix, acceptedHeaders[key]),
+			})
+		}
+	}
+	parent.Spec.Includes = includes
+	return results, r.setManagedIncludes(parent, newRecords)
+}
@@ -0,0 +1,855 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func childrenFromTemplate(namespace, parentNamespacedName string, count int) []*contourv1.HTTPProxy {
+	children := make([]*contourv1.HTTPProxy, count)
+	created := time.Now()
+	for i := range children {
+		name := fmt.Sprintf("%s-%d", TestChildNamespacePrefix, i)
+		children[i] = childProxyFromTemplate(namespace, name, parentNamespacedName, "")
+		children[i].UID = types.UID(name)
+		children[i].CreationTimestamp = v1.NewTime(created.Add(time.Duration(i) * time.Second))
+	}
+	return children
+}
+
+var _ = Describe("Include computation", func() {
+	reconciler := &HTTPProxyReconciler{}
+
+	It("Should include all children at once", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 3)
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parent.Spec.Includes).To(HaveLen(3))
+		for i, child := range children {
+			Expect(results[i].State).To(Equal(stateAttached))
+			Expect(hasInclude(parent, child.Namespace, child.Name, fmt.Sprintf("/%s", child.Name))).To(BeTrue())
+		}
+		records, err := reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(3))
+	})
+
+	It("Should reject children claiming an included prefix", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 2)
+		children[1].Annotations[pathPrefixAnnotation] = fmt.Sprintf("/%s", children[0].Name)
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateConflict))
+		Expect(results[1].Reason).To(Equal("DuplicatePrefix"))
+	})
+
+	It("Should resolve duplicate prefixes in favor of the current owner, then the oldest child", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 3)
+		prefix := "/shared"
+		for _, child := range children {
+			child.Annotations[pathPrefixAnnotation] = prefix
+		}
+
+		By("including a younger child first")
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children[1:], nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateConflict))
+		Expect(results[1].Message).To(ContainSubstring("child/child-1"))
+
+		By("adding the oldest child")
+		results, err = reconciler.computeIncludes(parent, "parent/parent", []*contourv1.HTTPProxy{children[2], children[1], children[0]}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateConflict))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].State).To(Equal(stateConflict))
+		Expect(results[2].Message).To(ContainSubstring("child/child-1"))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+		Expect(hasInclude(parent, "child", children[1].Name, prefix)).To(BeTrue())
+
+		By("removing the current owner")
+		results, err = reconciler.computeIncludes(parent, "parent/parent", []*contourv1.HTTPProxy{children[0], children[2]}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateConflict))
+		Expect(hasInclude(parent, "child", children[0].Name, prefix)).To(BeTrue())
+	})
+
+	It("Should not let an older child take over an included prefix", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[overlapPolicyAnnotation] = overlapPolicyDeny
+		rules, _ := annotationRules(parent)
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("child", "parent/parent", 3)
+		children[1].Annotations[pathPrefixAnnotation] = "/shared"
+		children[2].Annotations[pathPrefixAnnotation] = "/api"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		for _, result := range results {
+			Expect(result.State).To(Equal(stateAttached))
+		}
+
+		By("claiming the prefixes of younger children")
+		children[0].Annotations[pathPrefixAnnotation] = "/shared,/api/v1"
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateConflict))
+		Expect(results[0].Message).To(ContainSubstring("child/child-1"))
+		Expect(results[0].Message).To(ContainSubstring("child/child-2"))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].State).To(Equal(stateAttached))
+		Expect(hasInclude(parent, "child", children[1].Name, "/shared")).To(BeTrue())
+		Expect(hasInclude(parent, "child", children[2].Name, "/api")).To(BeTrue())
+	})
+
+	It("Should compare header conditions along with prefixes", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 4)
+		for _, child := range children {
+			child.Annotations[pathPrefixAnnotation] = "/api"
+		}
+		children[0].Annotations[headersAnnotation] = `[{"name": "x-tenant", "exact": "acme"}]`
+		children[1].Annotations[headersAnnotation] = `[{"name": "x-tenant", "exact": "other"}]`
+		children[2].Annotations[headersAnnotation] = `[{"name": "X-Tenant", "exact": "acme"}]`
+		children[3].Annotations[headersAnnotation] = `[{"name": "x-tenant", "exact": "acme", "present": true}]`
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].State).To(Equal(stateConflict))
+		Expect(results[2].Message).To(ContainSubstring("child/child-0"))
+		Expect(results[3].State).To(Equal(stateRejected))
+		Expect(results[3].Reason).To(Equal(reasonInvalidHeaders))
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+		Expect(parent.Spec.Includes[0].Conditions).To(ConsistOf(
+			contourv1.MatchCondition{Prefix: "/api"},
+			contourv1.MatchCondition{Header: &contourv1.HeaderMatchCondition{Name: "x-tenant", Exact: "acme"}},
+		))
+		records, err := reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records[1].Headers).To(ConsistOf(contourv1.HeaderMatchCondition{Name: "x-tenant", Exact: "other"}))
+	})
+
+	It("Should include each prefix of a child separately", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 2)
+		children[0].Annotations[pathPrefixAnnotation] = "/docs, /help,/docs"
+		children[1].Annotations[pathPrefixAnnotation] = "/help,/faq"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[0].Prefix).To(Equal("/docs,/help"))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[1].Prefix).To(Equal("/faq"))
+		Expect(results[1].Reason).To(Equal(reasonDuplicatePrefix))
+		Expect(results[1].Failures).To(Equal([]prefixFailure{
+			{Prefix: "/help", State: stateConflict, Reason: reasonDuplicatePrefix},
+		}))
+		Expect(results[1].eventType()).To(Equal(corev1.EventTypeWarning))
+		Expect(results[1].Message).To(ContainSubstring("claimed by child/child-0"))
+		Expect(parent.Spec.Includes).To(HaveLen(3))
+		Expect(hasInclude(parent, "child", children[0].Name, "/docs")).To(BeTrue())
+		Expect(hasInclude(parent, "child", children[0].Name, "/help")).To(BeTrue())
+		Expect(hasInclude(parent, "child", children[1].Name, "/faq")).To(BeTrue())
+		records, err := reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(3))
+
+		By("deleting the first child")
+		now := v1.Now()
+		children[0].DeletionTimestamp = &now
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateDetached))
+		Expect(results[1].Prefix).To(Equal("/help,/faq"))
+		Expect(results[1].Reason).To(Equal(reasonAttached))
+		Expect(results[1].Failures).To(BeEmpty())
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+		Expect(hasInclude(parent, "child", children[0].Name, "/docs")).To(BeFalse())
+		records, err = reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+	})
+
+	It("Should normalize and validate prefixes", func() {
+		for prefix, expected := range map[string]string{
+			"/":           "/",
+			"/blog/":      "/blog",
+			"blog":        "/blog",
+			"//blog//sub": "/blog/sub",
+			"/a-b_c.d~e":  "/a-b_c.d~e",
+			"/%E3%81%82":  "/%E3%81%82",
+		} {
+			normalized, err := normalizePrefix(prefix)
+			Expect(err).NotTo(HaveOccurred(), prefix)
+			Expect(normalized).To(Equal(expected), prefix)
+		}
+		for _, prefix := range []string{"", "/blog?x", "/blog#x", "/blog post", "/blog,faq", "/a=b", "/%zz", "/blog/../admin"} {
+			_, err := normalizePrefix(prefix)
+			Expect(err).To(HaveOccurred(), prefix)
+		}
+
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 3)
+		children[0].Annotations[pathPrefixAnnotation] = "/blog/"
+		children[1].Annotations[pathPrefixAnnotation] = "//blog"
+		children[2].Annotations[pathPrefixAnnotation] = "/blog?x"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[0].Prefix).To(Equal("/blog"))
+		Expect(results[1].State).To(Equal(stateConflict))
+		Expect(results[2].State).To(Equal(stateRejected))
+		Expect(results[2].Reason).To(Equal(reasonInvalidPrefix))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+		Expect(hasInclude(parent, "child", children[0].Name, "/blog")).To(BeTrue())
+
+		By("adding a child claiming the prefix of a hand-written include")
+		parent = parentProxyFromTemplate("parent", "parent")
+		parent.Spec.Includes = []contourv1.Include{
+			{
+				Namespace: "hoge",
+				Name:      "hoge",
+				Conditions: []contourv1.MatchCondition{
+					{Prefix: "/blog/"},
+				},
+			},
+		}
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children[:1], nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateConflict))
+		Expect(results[0].Message).To(ContainSubstring("added by hand for hoge/hoge"))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+	})
+
+	It("Should reject children colliding with the parent's routes", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Spec.Routes = []contourv1.Route{
+			{
+				Conditions: []contourv1.MatchCondition{
+					{Prefix: "/static/"},
+				},
+			},
+			{
+				Conditions: []contourv1.MatchCondition{
+					{Prefix: "/api"},
+					{Header: &contourv1.HeaderMatchCondition{Name: "x-tenant", Exact: "acme"}},
+				},
+			},
+		}
+		children := childrenFromTemplate("child", "parent/parent", 4)
+		children[0].Annotations[pathPrefixAnnotation] = "/static"
+		children[1].Annotations[pathPrefixAnnotation] = "/api"
+		children[2].Annotations[pathPrefixAnnotation] = "/api"
+		children[2].Annotations[headersAnnotation] = `[{"name": "x-tenant", "exact": "acme"}]`
+		children[3].Annotations[pathPrefixAnnotation] = "/static/css,/"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateConflict))
+		Expect(results[0].Reason).To(Equal(reasonRouteConflict))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].State).To(Equal(stateConflict))
+		Expect(results[2].Reason).To(Equal(reasonRouteConflict))
+		Expect(results[3].State).To(Equal(stateAttached))
+		Expect(results[3].Prefix).To(Equal("/static/css,/"))
+		Expect(results[3].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[3].Message).To(ContainSubstring("overlapping with prefix /static of a route of the parent"))
+		Expect(parent.Spec.Includes).To(HaveLen(3))
+
+		By("denying overlaps")
+		parent.Annotations[overlapPolicyAnnotation] = overlapPolicyDeny
+		rules, _ := annotationRules(parent)
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[3].State).To(Equal(stateConflict))
+		Expect(results[3].Reason).To(Equal(reasonRouteConflict))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+
+		By("adding a catch-all route")
+		delete(parent.Annotations, overlapPolicyAnnotation)
+		parent.Spec.Routes = []contourv1.Route{{}}
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children[:1], nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[0].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[0].eventType()).To(Equal(corev1.EventTypeWarning))
+	})
+
+	It("Should remove includes of departed children only", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Spec.Includes = []contourv1.Include{
+			{
+				Namespace: "hoge",
+				Name:      "hoge",
+				Conditions: []contourv1.MatchCondition{
+					{
+						Prefix: "/hoge",
+					},
+				},
+			},
+		}
+		children := childrenFromTemplate("child", "parent/parent", 2)
+		_, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parent.Spec.Includes).To(HaveLen(3))
+
+		By("deleting a child")
+		now := v1.Now()
+		children[0].DeletionTimestamp = &now
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateDetached))
+		Expect(results[0].Release).To(BeTrue())
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+		Expect(hasInclude(parent, "hoge", "hoge", "/hoge")).To(BeTrue())
+
+		By("forgetting about a child")
+		_, err = reconciler.computeIncludes(parent, "parent/parent", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+		Expect(hasInclude(parent, "hoge", "hoge", "/hoge")).To(BeTrue())
+		Expect(parent.Annotations).NotTo(HaveKey(managedIncludesAnnotation))
+	})
+
+	It("Should keep hand-written includes of included children", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 1)
+		children[0].Annotations[pathPrefixAnnotation] = "/a"
+		_, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hasInclude(parent, "child", children[0].Name, "/a")).To(BeTrue())
+
+		By("adding an include for the child by hand")
+		parent.Spec.Includes = append(parent.Spec.Includes, contourv1.Include{
+			Namespace: "child",
+			Name:      children[0].Name,
+			Conditions: []contourv1.MatchCondition{
+				{Prefix: "/handwritten"},
+			},
+		})
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateConflict))
+		Expect(results[0].Reason).To(Equal(reasonIncludeConflict))
+		Expect(hasInclude(parent, "child", children[0].Name, "/handwritten")).To(BeTrue())
+	})
+
+	It("Should adopt includes of children predating the bookkeeping annotations", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 2)
+		for _, child := range children {
+			controllerutil.AddFinalizer(child, finalizerName)
+			parent.Spec.Includes = append(parent.Spec.Includes, contourv1.Include{
+				Namespace: child.Namespace,
+				Name:      child.Name,
+				Conditions: []contourv1.MatchCondition{
+					{Prefix: fmt.Sprintf("/%s", child.Name)},
+				},
+			})
+		}
+		Expect(parent.Annotations).NotTo(HaveKey(managedIncludesAnnotation))
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+		records, err := reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+
+		By("deleting a child before it was ever reconciled")
+		parent.Annotations = map[string]string{allowInclusionAnnotation: "true"}
+		now := v1.Now()
+		children[0].DeletionTimestamp = &now
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateDetached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+		Expect(hasInclude(parent, "child", children[0].Name, fmt.Sprintf("/%s", children[0].Name))).To(BeFalse())
+	})
+
+	It("Should reject children breaking the parent's policy", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		delete(parent.Annotations, allowInclusionAnnotation)
+		maxChildren := int32(2)
+		policy := &parentPolicy{
+			Selected: true,
+			Rules: []policyRules{
+				{
+					Source: "InclusionPolicy test",
+					Spec: oyakov1alpha1.InclusionPolicySpec{
+						AllowedNamespaces: []string{"child"},
+						ReservedPrefixes:  []string{"/admin"},
+						MaxChildren:       &maxChildren,
+					},
+				},
+			},
+		}
+		children := childrenFromTemplate("child", "parent/parent", 5)
+		children[1].Annotations[pathPrefixAnnotation] = "/admin/users"
+		children[2].Namespace = "other"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateRejected))
+		Expect(results[1].Reason).To(Equal(reasonPrefixReserved))
+		Expect(results[2].State).To(Equal(stateRejected))
+		Expect(results[2].Reason).To(Equal(reasonNamespaceNotAllowed))
+		Expect(results[3].State).To(Equal(stateAttached))
+		Expect(results[4].State).To(Equal(stateRejected))
+		Expect(results[4].Reason).To(Equal(reasonChildLimitReached))
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+	})
+
+	It("Should let the parent's annotation revoke inclusion on a selected parent", func() {
+		reconciler := &HTTPProxyReconciler{DefaultRevocationMode: RevocationModeDetach}
+		parent := parentProxyFromTemplate("parent", "parent")
+		delete(parent.Annotations, allowInclusionAnnotation)
+		policy := &parentPolicy{Selected: true}
+		children := childrenFromTemplate("child", "parent/parent", 1)
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+
+		By("revoking inclusion")
+		parent.Annotations[allowInclusionAnnotation] = "false"
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateDetached))
+		Expect(results[0].Reason).To(Equal(reasonInclusionRevoked))
+		Expect(parent.Spec.Includes).To(BeEmpty())
+	})
+
+	It("Should only include children allowed by the parent's annotations", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[allowedNamespacesAnnotation] = "child, blog"
+		parent.Annotations[allowedNamespaceSelectorAnnotation] = "team=sales"
+		parent.Annotations[childSelectorAnnotation] = "exposed!=false"
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		Expect(rules.Err).NotTo(HaveOccurred())
+		policy := &parentPolicy{
+			Rules: []policyRules{rules},
+			NamespaceLabels: map[string]labels.Set{
+				"sales": {"team": "sales"},
+			},
+		}
+		children := childrenFromTemplate("child", "parent/parent", 4)
+		children[1].Namespace = "sales"
+		children[2].Namespace = "other"
+		children[3].Labels = map[string]string{"exposed": "false"}
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].State).To(Equal(stateRejected))
+		Expect(results[2].Reason).To(Equal(reasonNamespaceNotAllowed))
+		Expect(results[3].State).To(Equal(stateRejected))
+		Expect(results[3].Reason).To(Equal(reasonChildNotAllowed))
+
+		By("setting an invalid selector")
+		parent.Annotations[childSelectorAnnotation] = "exposed in (true"
+		rules, _ = annotationRules(parent)
+		Expect(rules.Err).To(HaveOccurred())
+		policy.Rules = []policyRules{rules}
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		for _, result := range results {
+			Expect(result.Reason).To(Equal(reasonInvalidPolicy))
+		}
+		Expect(parent.Spec.Includes).To(BeEmpty())
+	})
+
+	It("Should enforce reserved and assigned prefixes", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[reservedPrefixesAnnotation] = `{"/": [], "/admin": [], "/api": ["api"]}`
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		Expect(rules.Err).NotTo(HaveOccurred())
+		Expect(rules.Spec.ReservedPrefixes).To(Equal([]string{"/", "/admin"}))
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("child", "parent/parent", 5)
+		children[0].Annotations[pathPrefixAnnotation] = "/"
+		children[1].Annotations[pathPrefixAnnotation] = "/admin/users"
+		children[2].Annotations[pathPrefixAnnotation] = "/api/v1"
+		children[3].Annotations[pathPrefixAnnotation] = "/api/v2"
+		children[3].Namespace = "api"
+		children[4].Annotations[pathPrefixAnnotation] = "/apiv2"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Reason).To(Equal(reasonPrefixReserved))
+		Expect(results[1].Reason).To(Equal(reasonPrefixReserved))
+		Expect(results[2].Reason).To(Equal(reasonPrefixAssigned))
+		Expect(results[3].State).To(Equal(stateAttached))
+		Expect(results[4].State).To(Equal(stateAttached))
+	})
+
+	It("Should normalize reserved and assigned prefixes", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[reservedPrefixesAnnotation] = `{"/admin/": [], "api//": ["api"]}`
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		Expect(rules.Err).NotTo(HaveOccurred())
+		Expect(rules.Spec.ReservedPrefixes).To(Equal([]string{"/admin"}))
+		Expect(rules.Spec.AssignedPrefixes[0].Prefix).To(Equal("/api"))
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("child", "parent/parent", 3)
+		children[0].Annotations[pathPrefixAnnotation] = "/admin"
+		children[1].Annotations[pathPrefixAnnotation] = "/api"
+		children[2].Annotations[pathPrefixAnnotation] = "/api"
+		children[2].Namespace = "api"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Reason).To(Equal(reasonPrefixReserved))
+		Expect(results[1].Reason).To(Equal(reasonPrefixAssigned))
+		Expect(results[2].State).To(Equal(stateAttached))
+
+		By("normalizing the prefixes of an InclusionPolicy")
+		spec := oyakov1alpha1.InclusionPolicySpec{
+			AllowedPrefixes:  []string{"/blog/"},
+			ReservedPrefixes: []string{"//admin"},
+			AssignedPrefixes: []oyakov1alpha1.PrefixAssignment{{Prefix: "api/", Namespaces: []string{"api"}}},
+		}
+		Expect(normalizePolicyPrefixes(&spec)).To(Succeed())
+		Expect(spec.AllowedPrefixes).To(Equal([]string{"/blog"}))
+		Expect(spec.ReservedPrefixes).To(Equal([]string{"/admin"}))
+		Expect(spec.AssignedPrefixes[0].Prefix).To(Equal("/api"))
+
+		By("reserving an invalid prefix")
+		parent.Annotations[reservedPrefixesAnnotation] = `{"/admin/../api": []}`
+		rules, _ = annotationRules(parent)
+		Expect(rules.Err).To(HaveOccurred())
+	})
+
+	It("Should enforce prefix patterns", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[prefixPatternsAnnotation] = "/{namespace}, /teams/{namespace}/{name}"
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("sales", "parent/parent", 4)
+		children[0].Annotations[pathPrefixAnnotation] = "/sales/shop"
+		children[1].Annotations[pathPrefixAnnotation] = fmt.Sprintf("/teams/sales/%s", children[1].Name)
+		children[2].Annotations[pathPrefixAnnotation] = "/salesforce"
+		children[3].Annotations[pathPrefixAnnotation] = "/blog"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].Reason).To(Equal(reasonPrefixPatternMismatch))
+		Expect(results[3].Reason).To(Equal(reasonPrefixPatternMismatch))
+	})
+
+	It("Should enforce child quotas", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[maxChildrenAnnotation] = "3"
+		parent.Annotations[maxPrefixesPerNamespaceAnnotation] = "2"
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		Expect(rules.Err).NotTo(HaveOccurred())
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("child", "parent/parent", 5)
+		children[3].Namespace = "other"
+		children[4].Namespace = "another"
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[2].Reason).To(Equal(reasonNamespaceLimitReached))
+		Expect(results[3].State).To(Equal(stateAttached))
+		Expect(results[4].Reason).To(Equal(reasonChildLimitReached))
+
+		By("setting an invalid limit")
+		parent.Annotations[maxChildrenAnnotation] = "-1"
+		rules, _ = annotationRules(parent)
+		Expect(rules.Err).To(HaveOccurred())
+	})
+
+	It("Should apply the overlap policy", func() {
+		Expect(prefixesOverlap("/api", "/api/v1")).To(BeTrue())
+		Expect(prefixesOverlap("/apiv2", "/api")).To(BeTrue())
+		Expect(prefixesOverlap("/api", "/app")).To(BeFalse())
+		Expect(prefixesOverlap("/api", "/api")).To(BeFalse())
+
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 4)
+		children[0].Annotations[pathPrefixAnnotation] = "/api"
+		children[1].Annotations[pathPrefixAnnotation] = "/api/v1"
+		children[2].Annotations[pathPrefixAnnotation] = "/apiv2"
+		children[3].Annotations[pathPrefixAnnotation] = "/api/v2"
+		children[3].Annotations[headersAnnotation] = `[{"name": "x-tenant", "exact": "acme"}]`
+
+		By("allowing overlaps by default")
+		results, err := reconciler.computeIncludes(parent.DeepCopy(), "parent/parent", children, nil)
+		Expect(err).NotTo(HaveOccurred())
+		for _, result := range results {
+			Expect(result.State).To(Equal(stateAttached))
+			Expect(result.Reason).To(Equal(reasonAttached))
+		}
+
+		By("warning about overlaps")
+		parent.Annotations[overlapPolicyAnnotation] = overlapPolicyWarn
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		Expect(rules.Err).NotTo(HaveOccurred())
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		results, err = reconciler.computeIncludes(parent.DeepCopy(), "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Reason).To(Equal(reasonAttached))
+		Expect(results[1].State).To(Equal(stateAttached))
+		Expect(results[1].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[1].eventType()).To(Equal(corev1.EventTypeWarning))
+		Expect(results[2].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[3].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[3].Message).To(ContainSubstring("child/child-0"))
+
+		By("denying overlaps")
+		parent.Annotations[overlapPolicyAnnotation] = overlapPolicyDeny
+		rules, _ = annotationRules(parent)
+		policy = &parentPolicy{Rules: []policyRules{rules}}
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(stateConflict))
+		Expect(results[1].Reason).To(Equal(reasonOverlappingPrefix))
+		Expect(results[1].Message).To(ContainSubstring("child/child-0"))
+		Expect(results[2].State).To(Equal(stateConflict))
+		Expect(results[3].State).To(Equal(stateConflict))
+		Expect(parent.Spec.Includes).To(HaveLen(1))
+
+		By("comparing header conditions")
+		acme := describeHeaders([]contourv1.HeaderMatchCondition{{Name: "x-tenant", Exact: "acme"}})
+		other := describeHeaders([]contourv1.HeaderMatchCondition{{Name: "x-tenant", Exact: "other"}})
+		claims := []prefixClaim{{Prefix: "/api", Headers: acme, Owner: types.NamespacedName{Namespace: "child", Name: "a"}}}
+		key := types.NamespacedName{Namespace: "child", Name: "b"}
+		Expect(overlappingClaim(claims, key, "/api/v1", acme, false)).NotTo(BeNil())
+		Expect(overlappingClaim(claims, key, "/api/v1", other, false)).To(BeNil())
+		Expect(overlappingClaim(claims, key, "/api/v1", "", false)).NotTo(BeNil())
+		claims[0].Headers = ""
+		Expect(overlappingClaim(claims, key, "/api/v1", other, false)).NotTo(BeNil())
+
+		By("setting an invalid policy")
+		parent.Annotations[overlapPolicyAnnotation] = "maybe"
+		rules, _ = annotationRules(parent)
+		Expect(rules.Err).To(HaveOccurred())
+	})
+
+	It("Should wait for new inclusions to be approved", func() {
+		parent := parentProxyFromTemplate("parent", "parent")
+		parent.Annotations[requireApprovalAnnotation] = "true"
+		parent.Annotations[approvedInclusionsAnnotation] = "child/child-0@/child-0/=alice, child/child-1@/child-1, child/child-2@/a/../b=bob"
+		rules, ok := annotationRules(parent)
+		Expect(ok).To(BeTrue())
+		policy := &parentPolicy{Rules: []policyRules{rules}}
+		children := childrenFromTemplate("child", "parent/parent", 2)
+		Expect(parseApprovals(parent.Annotations[approvedInclusionsAnnotation])).To(Equal(map[string]string{
+			"child/child-0@/child-0": "alice",
+		}))
+
+		results, err := reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(statePending))
+		Expect(results[1].Reason).To(Equal(reasonApprovalRequired))
+		records, err := reconciler.getManagedIncludes(parent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].ApprovedBy).To(Equal("alice"))
+
+		By("removing the approval of an included child")
+		delete(parent.Annotations, approvedInclusionsAnnotation)
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(stateAttached))
+		Expect(results[1].State).To(Equal(statePending))
+
+		By("changing the prefix of an included child")
+		children[0].Annotations[pathPrefixAnnotation] = "/other"
+		results, err = reconciler.computeIncludes(parent, "parent/parent", children, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].State).To(Equal(statePending))
+		Expect(parent.Spec.Includes).To(BeEmpty())
+	})
+
+	It("Should record events for children added, updated and removed", func() {
+		recorder := record.NewFakeRecorder(10)
+		reconciler := &HTTPProxyReconciler{Recorder: recorder}
+		parent := parentProxyFromTemplate("parent", "parent")
+		before := []managedInclude{
+			{Namespace: "child", Name: "a", Prefix: "/a"},
+			{Namespace: "child", Name: "b", Prefix: "/b"},
+		}
+		after := []managedInclude{
+			{Namespace: "child", Name: "b", Prefix: "/bb"},
+			{Namespace: "child", Name: "c", Prefix: "/c"},
+		}
+
+		reconciler.recordParentEvents(parent, before, after)
+		close(recorder.Events)
+		var events []string
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		Expect(events).To(ConsistOf(
+			"Normal ChildUpdated Updated include for child/b from prefix /b to /bb",
+			"Normal ChildAdded Included child/c with prefix /c",
+			"Normal ChildRemoved Removed include for child/a with prefix /a",
+		))
+	})
+})
+
+var _ = Describe("Inclusion status", func() {
+	reconciler := &HTTPProxyReconciler{}
+
+	It("Should only update the transition time when the state changes", func() {
+		child := childProxyFromTemplate("child", "child", "parent/parent", "/child")
+		child.Generation = 2
+		Expect(reconciler.setInclusionStatus(child, "parent/parent", childResult{State: statePending, Reason: reasonParentNotFound})).To(Succeed())
+		status := getInclusionStatus(child)
+		Expect(status).NotTo(BeNil())
+		Expect(status.State).To(Equal(statePending))
+		Expect(status.Parent).To(Equal("parent/parent"))
+		Expect(status.ObservedGeneration).To(Equal(int64(2)))
+
+		By("updating the status in the same state")
+		transitioned := v1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		status.LastTransitionTime = transitioned
+		value, err := json.Marshal(status)
+		Expect(err).NotTo(HaveOccurred())
+		child.Annotations[statusAnnotation] = string(value)
+		Expect(reconciler.setInclusionStatus(child, "parent/parent", childResult{State: statePending, Reason: reasonParentNotFound})).To(Succeed())
+		Expect(getInclusionStatus(child).LastTransitionTime.Equal(&transitioned)).To(BeTrue())
+
+		By("changing the state")
+		Expect(reconciler.setInclusionStatus(child, "parent/parent", childResult{State: stateAttached, Prefix: "/child"})).To(Succeed())
+		status = getInclusionStatus(child)
+		Expect(status.State).To(Equal(stateAttached))
+		Expect(status.Prefix).To(Equal("/child"))
+		Expect(status.LastTransitionTime.Time).To(BeTemporally(">", transitioned.Time))
+	})
+})
+
+var _ = Describe("Inclusion metrics", func() {
+	It("Should count children by state and forget parents without children", func() {
+		recordChildStates("metrics/parent", []childResult{
+			{State: stateAttached},
+			{State: stateAttached},
+			{State: stateConflict},
+			{State: statePending},
+			{State: stateAttached, Failures: []prefixFailure{
+				{Prefix: "/a", State: stateConflict},
+				{Prefix: "/b", State: stateConflict},
+			}},
+		})
+		Expect(testutil.ToFloat64(attachedChildren.WithLabelValues("metrics/parent"))).To(Equal(float64(3)))
+		Expect(testutil.ToFloat64(conflictingChildren.WithLabelValues("metrics/parent"))).To(Equal(float64(2)))
+		Expect(testutil.ToFloat64(pendingChildren.WithLabelValues("metrics/parent"))).To(Equal(float64(1)))
+
+		By("removing all children")
+		recordChildStates("metrics/parent", nil)
+		Expect(testutil.CollectAndCount(attachedChildren)).To(BeZero())
+		Expect(testutil.CollectAndCount(conflictingChildren)).To(BeZero())
+		Expect(testutil.CollectAndCount(pendingChildren)).To(BeZero())
+	})
+})
+
+var _ = Describe("Parent freeze", func() {
+	It("Should parse freeze windows", func() {
+		windows, err := ParseFreezeWindows("2026-12-20T00:00:00Z/2027-01-05T00:00:00Z, 2027-02-01T00:00:00+09:00/2027-02-02T00:00:00+09:00")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(windows).To(HaveLen(2))
+		Expect(windows[0].End.Sub(windows[0].Start)).To(Equal(16 * 24 * time.Hour))
+
+		_, err = ParseFreezeWindows("2026-12-20T00:00:00Z")
+		Expect(err).To(HaveOccurred())
+		_, err = ParseFreezeWindows("2027-01-05T00:00:00Z/2026-12-20T00:00:00Z")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should freeze parents during freeze windows", func() {
+		now := time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC)
+		reconciler := &HTTPProxyReconciler{
+			FreezeWindows: []FreezeWindow{
+				{Start: now.Add(24 * time.Hour), End: now.Add(48 * time.Hour)},
+			},
+		}
+		parent := parentProxyFromTemplate("parent", "parent")
+		Expect(reconciler.getParentFreeze(parent, "parent/parent", now)).To(BeNil())
+
+		By("adding a window on the parent")
+		parent.Annotations[freezeWindowsAnnotation] = fmt.Sprintf("%s/%s", now.Add(-time.Hour).Format(time.RFC3339), now.Add(24*time.Hour).Format(time.RFC3339))
+		freeze := reconciler.getParentFreeze(parent, "parent/parent", now)
+		Expect(freeze).NotTo(BeNil())
+		Expect(freeze.Until).To(Equal(now.Add(48 * time.Hour)))
+
+		By("freezing the parent")
+		parent.Annotations[freezeAnnotation] = "true"
+		freeze = reconciler.getParentFreeze(parent, "parent/parent", now)
+		Expect(freeze).NotTo(BeNil())
+		Expect(freeze.Until.IsZero()).To(BeTrue())
+	})
+
+	It("Should release deleted children predating the bookkeeping annotations", func() {
+		reconciler := &HTTPProxyReconciler{}
+		parent := parentProxyFromTemplate("parent", "parent")
+		children := childrenFromTemplate("child", "parent/parent", 3)
+		for _, child := range children {
+			controllerutil.AddFinalizer(child, finalizerName)
+			parent.Spec.Includes = append(parent.Spec.Includes, contourv1.Include{
+				Namespace: child.Namespace,
+				Name:      child.Name,
+				Conditions: []contourv1.MatchCondition{
+					{Prefix: fmt.Sprintf("/%s", child.Name)},
+				},
+			})
+		}
+		now := v1.Now()
+		children[0].DeletionTimestamp = &now
+		children[1].DeletionTimestamp = &now
+		children[1].Annotations[parentRefAnnotation] = "other/parent"
+
+		deleted := deletedChildren(children, "parent/parent")
+		Expect(deleted).To(HaveLen(1))
+		Expect(deleted).To(HaveKey(types.NamespacedName{Namespace: "child", Name: children[0].Name}))
+		Expect(reconciler.releaseDeletedChildren(parent, "parent/parent", deleted)).To(Succeed())
+		Expect(parent.Spec.Includes).To(HaveLen(2))
+		Expect(hasInclude(parent, "child", children[0].Name, fmt.Sprintf("/%s", children[0].Name))).To(BeFalse())
+	})
+})
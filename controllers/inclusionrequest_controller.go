@@ -0,0 +1,292 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
+	"github.com/go-logr/logr"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	inclusionRequestAnnotation = "oyako.atelierhsn.com/inclusion-request"
+
+	inclusionRequestIndex = ".metadata.annotations.inclusion-request"
+	httpProxyIndex        = ".spec.httpProxy"
+
+	reasonAccepted          = "Accepted"
+	reasonHTTPProxyNotFound = "HTTPProxyNotFound"
+	reasonParentRefInUse    = "ParentRefInUse"
+)
+
+// InclusionRequestReconciler reconciles an InclusionRequest object. Requests
+// are applied to the child HTTPProxy as the annotations understood by
+// HTTPProxyReconciler, and the inclusion status of the child is reported
+// back in the status of the request.
+type InclusionRequestReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=oyako.atelierhsn.com,resources=inclusionrequests,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=oyako.atelierhsn.com,resources=inclusionrequests/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=oyako.atelierhsn.com,resources=inclusionrequests/finalizers,verbs=update
+// +kubebuilder:rbac:groups=projectcontour.io,resources=httpproxies,verbs=get;list;watch;update;patch
+
+// Reconcile applies an InclusionRequest to its child HTTPProxy.
+func (r *InclusionRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("inclusionrequest", req.NamespacedName)
+
+	ir := &oyakov1alpha1.InclusionRequest{}
+	if err := r.Client.Get(ctx, req.NamespacedName, ir); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !ir.DeletionTimestamp.IsZero() {
+		if err := r.releaseHTTPProxies(ctx, ir, ""); err != nil {
+			log.Error(err, "unable to release HTTPProxy")
+			return ctrl.Result{}, err
+		}
+		if !controllerutil.ContainsFinalizer(ir, finalizerName) {
+			return ctrl.Result{}, nil
+		}
+		controllerutil.RemoveFinalizer(ir, finalizerName)
+		return ctrl.Result{}, r.Client.Update(ctx, ir)
+	}
+	if !controllerutil.ContainsFinalizer(ir, finalizerName) {
+		controllerutil.AddFinalizer(ir, finalizerName)
+		if err := r.Client.Update(ctx, ir); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	// The request may have been pointed at another HTTPProxy.
+	if err := r.releaseHTTPProxies(ctx, ir, ir.Spec.HTTPProxy); err != nil {
+		log.Error(err, "unable to release HTTPProxy")
+		return ctrl.Result{}, err
+	}
+
+	orig := ir.DeepCopy()
+	if err := r.applyRequest(ctx, ir); err != nil {
+		log.Error(err, "unable to apply InclusionRequest")
+		return ctrl.Result{}, err
+	}
+	if equality.Semantic.DeepEqual(orig.Status, ir.Status) {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, r.Client.Status().Update(ctx, ir)
+}
+
+// applyRequest sets the parent and prefix annotations of the child HTTPProxy
+// from the request, and updates the status of the request from the child.
+// HTTPProxy objects that already reference a parent through annotations set
+// by hand or by another request are left alone.
+func (r *InclusionRequestReconciler) applyRequest(ctx context.Context, ir *oyakov1alpha1.InclusionRequest) error {
+	ir.Status.ObservedGeneration = ir.Generation
+	parentRef := fmt.Sprintf("%s/%s", ir.Spec.Parent.Namespace, ir.Spec.Parent.Name)
+
+	child := &contourv1.HTTPProxy{}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: ir.Namespace, Name: ir.Spec.HTTPProxy}, child)
+	if apierrors.IsNotFound(err) {
+		message := fmt.Sprintf("HTTPProxy %s not found", ir.Spec.HTTPProxy)
+		r.setRequestStatus(ir, statePending, "", v1.ConditionFalse, reasonHTTPProxyNotFound, message)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner := child.Annotations[inclusionRequestAnnotation]; owner != ir.Name && child.Annotations[parentRefAnnotation] != "" {
+		message := fmt.Sprintf("HTTPProxy %s already references parent %s", ir.Spec.HTTPProxy, child.Annotations[parentRefAnnotation])
+		if owner != "" {
+			message = fmt.Sprintf("HTTPProxy %s is already requested by InclusionRequest %s", ir.Spec.HTTPProxy, owner)
+		}
+		r.setRequestStatus(ir, stateConflict, "", v1.ConditionFalse, reasonParentRefInUse, message)
+		return nil
+	}
+
+	orig := child.DeepCopy()
+	if child.Annotations == nil {
+		child.Annotations = map[string]string{}
+	}
+	child.Annotations[inclusionRequestAnnotation] = ir.Name
+	child.Annotations[parentRefAnnotation] = parentRef
+	prefixes := ir.Spec.Prefixes
+	if ir.Spec.Prefix != "" {
+		prefixes = append([]string{ir.Spec.Prefix}, prefixes...)
+	}
+	if len(prefixes) != 0 {
+		child.Annotations[pathPrefixAnnotation] = strings.Join(prefixes, ",")
+	} else {
+		delete(child.Annotations, pathPrefixAnnotation)
+	}
+	// The header conditions share the JSON representation of those of
+	// Contour.
+	if len(ir.Spec.Headers) != 0 {
+		headers, err := json.Marshal(ir.Spec.Headers)
+		if err != nil {
+			return err
+		}
+		child.Annotations[headersAnnotation] = string(headers)
+	} else {
+		delete(child.Annotations, headersAnnotation)
+	}
+	if !equality.Semantic.DeepEqual(orig, child) {
+		if err := r.Client.Update(ctx, child); err != nil {
+			return err
+		}
+	}
+
+	meta.SetStatusCondition(&ir.Status.Conditions, v1.Condition{
+		Type:               oyakov1alpha1.ConditionAccepted,
+		Status:             v1.ConditionTrue,
+		ObservedGeneration: ir.Generation,
+		Reason:             reasonAccepted,
+		Message:            fmt.Sprintf("Applied to HTTPProxy %s", ir.Spec.HTTPProxy),
+	})
+	status := getInclusionStatus(child)
+	if status == nil || status.Parent != parentRef {
+		r.setRequestStatus(ir, statePending, "", v1.ConditionTrue, reasonAccepted, fmt.Sprintf("Waiting for parent %s to be reconciled", parentRef))
+		return nil
+	}
+	reason := status.Reason
+	if reason == "" {
+		reason = status.State
+	}
+	r.setRequestStatus(ir, status.State, status.Prefix, v1.ConditionTrue, reason, status.Message)
+	// Children attached under only some of their prefixes are not fully
+	// attached.
+	if len(status.FailedPrefixes) != 0 {
+		meta.SetStatusCondition(&ir.Status.Conditions, v1.Condition{
+			Type:               oyakov1alpha1.ConditionAttached,
+			Status:             v1.ConditionFalse,
+			ObservedGeneration: ir.Generation,
+			Reason:             reason,
+			Message:            status.Message,
+		})
+	}
+	return nil
+}
+
+// setRequestStatus records the inclusion state of the child in the status of
+// the request. The Accepted condition is only set here when the request
+// could not be applied.
+func (r *InclusionRequestReconciler) setRequestStatus(ir *oyakov1alpha1.InclusionRequest, state, prefix string, accepted v1.ConditionStatus, reason, message string) {
+	ir.Status.State = state
+	ir.Status.Prefix = prefix
+	if accepted == v1.ConditionFalse {
+		meta.SetStatusCondition(&ir.Status.Conditions, v1.Condition{
+			Type:               oyakov1alpha1.ConditionAccepted,
+			Status:             v1.ConditionFalse,
+			ObservedGeneration: ir.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+	attached := v1.ConditionFalse
+	if state == stateAttached {
+		attached = v1.ConditionTrue
+	}
+	meta.SetStatusCondition(&ir.Status.Conditions, v1.Condition{
+		Type:               oyakov1alpha1.ConditionAttached,
+		Status:             attached,
+		ObservedGeneration: ir.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// releaseHTTPProxies removes the annotations set by the request from the
+// HTTPProxy objects it was applied to, except for keep. HTTPProxyReconciler
+// then detaches them from their parent.
+func (r *InclusionRequestReconciler) releaseHTTPProxies(ctx context.Context, ir *oyakov1alpha1.InclusionRequest, keep string) error {
+	list := &contourv1.HTTPProxyList{}
+	if err := r.Client.List(ctx, list, client.InNamespace(ir.Namespace), client.MatchingFields{inclusionRequestIndex: ir.Name}); err != nil {
+		return err
+	}
+	for idx := range list.Items {
+		child := &list.Items[idx]
+		if child.Name == keep {
+			continue
+		}
+		delete(child.Annotations, inclusionRequestAnnotation)
+		delete(child.Annotations, parentRefAnnotation)
+		delete(child.Annotations, pathPrefixAnnotation)
+		delete(child.Annotations, headersAnnotation)
+		if err := r.Client.Update(ctx, child); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestsForHTTPProxy maps an HTTPProxy to reconcile requests for the
+// InclusionRequest objects targeting it or applied to it.
+func (r *InclusionRequestReconciler) requestsForHTTPProxy(obj client.Object) []reconcile.Request {
+	list := &oyakov1alpha1.InclusionRequestList{}
+	if err := r.Client.List(context.Background(), list, client.InNamespace(obj.GetNamespace()), client.MatchingFields{httpProxyIndex: obj.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list InclusionRequest")
+		return nil
+	}
+	var requests []reconcile.Request
+	seen := make(map[string]bool)
+	for idx := range list.Items {
+		ir := &list.Items[idx]
+		seen[ir.Name] = true
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(ir)})
+	}
+	if name := obj.GetAnnotations()[inclusionRequestAnnotation]; name != "" && !seen[name] {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: obj.GetNamespace(), Name: name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *InclusionRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(context.Background(), &contourv1.HTTPProxy{}, inclusionRequestIndex, func(obj client.Object) []string {
+		if name := obj.GetAnnotations()[inclusionRequestAnnotation]; name != "" {
+			return []string{name}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(context.Background(), &oyakov1alpha1.InclusionRequest{}, httpProxyIndex, func(obj client.Object) []string {
+		return []string{obj.(*oyakov1alpha1.InclusionRequest).Spec.HTTPProxy}
+	}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&oyakov1alpha1.InclusionRequest{}).
+		Watches(&source.Kind{Type: &contourv1.HTTPProxy{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForHTTPProxy)).
+		Complete(r)
+}
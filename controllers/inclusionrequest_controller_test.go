@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func inclusionRequestFromTemplate(namespace, name, httpProxy, parentNamespace, parentName, prefix string) *oyakov1alpha1.InclusionRequest {
+	return &oyakov1alpha1.InclusionRequest{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: oyakov1alpha1.InclusionRequestSpec{
+			HTTPProxy: httpProxy,
+			Parent: oyakov1alpha1.ParentReference{
+				Namespace: parentNamespace,
+				Name:      parentName,
+			},
+			Prefix: prefix,
+		},
+	}
+}
+
+var _ = Describe("InclusionRequest controller", func() {
+	var stopFunc func()
+	ctx := context.Background()
+	BeforeEach(func() {
+		k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:             scheme,
+			LeaderElection:     false,
+			MetricsBindAddress: "0",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect((&HTTPProxyReconciler{
+			Client:   k8sManager.GetClient(),
+			Scheme:   k8sManager.GetScheme(),
+			Log:      ctrl.Log.WithName("controllers").WithName("HTTPProxy"),
+			Recorder: k8sManager.GetEventRecorderFor("oyako"),
+		}).SetupWithManager(k8sManager)).To(Succeed())
+		Expect((&InclusionRequestReconciler{
+			Client: k8sManager.GetClient(),
+			Scheme: k8sManager.GetScheme(),
+			Log:    ctrl.Log.WithName("controllers").WithName("InclusionRequest"),
+		}).SetupWithManager(k8sManager)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(ctx)
+		stopFunc = cancel
+		go func() {
+			err := k8sManager.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	AfterEach(func() {
+		stopFunc()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	It("Should include the child and report its status", func() {
+		By("creating namespaces")
+		parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+		})).To(Succeed())
+
+		By("creating parent")
+		parent := parentProxyFromTemplate(parentNamespace, parentName)
+		Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+		By("creating child without annotations")
+		child := childProxyFromTemplate(childNamespace, childName, "", "")
+		child.Annotations = nil
+		Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+		By("creating InclusionRequest")
+		ir := inclusionRequestFromTemplate(childNamespace, childName, childName, parentNamespace, parentName, prefix)
+		Expect(k8sClient.Create(ctx, ir)).To(Succeed())
+
+		By("getting parent")
+		time.Sleep(time.Second)
+		Eventually(func() error {
+			return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+		}).Should(Succeed())
+
+		By("getting InclusionRequest status")
+		Eventually(func() bool {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(ir), ir)).To(Succeed())
+			return ir.Status.State == stateAttached &&
+				ir.Status.Prefix == prefix &&
+				meta.IsStatusConditionTrue(ir.Status.Conditions, oyakov1alpha1.ConditionAttached)
+		}).Should(BeTrue())
+
+		By("deleting InclusionRequest")
+		Expect(k8sClient.Delete(ctx, ir)).To(Succeed())
+
+		By("getting parent")
+		time.Sleep(time.Second)
+		Eventually(func() error {
+			return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+		}).ShouldNot(Succeed())
+		Eventually(func() map[string]string {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(child), child)).To(Succeed())
+			return child.Annotations
+		}).ShouldNot(HaveKey(parentRefAnnotation))
+	})
+
+	It("Should not override a parent set by annotations", func() {
+		By("creating namespaces")
+		parentNamespace, parentName, childNamespace, childName, prefix := randomNames()
+
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: parentNamespace},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: v1.ObjectMeta{Name: childNamespace},
+		})).To(Succeed())
+
+		By("creating parent")
+		parent := parentProxyFromTemplate(parentNamespace, parentName)
+		Expect(k8sClient.Create(ctx, parent)).To(Succeed())
+
+		By("creating child with annotations")
+		child := childProxyFromTemplate(childNamespace, childName, fmt.Sprintf("%s/%s", parentNamespace, parentName), prefix)
+		Expect(k8sClient.Create(ctx, child)).To(Succeed())
+
+		By("creating InclusionRequest")
+		ir := inclusionRequestFromTemplate(childNamespace, childName, childName, parentNamespace, parentName, fmt.Sprintf("/%s", randomSuffix()))
+		Expect(k8sClient.Create(ctx, ir)).To(Succeed())
+
+		By("getting InclusionRequest status")
+		time.Sleep(time.Second)
+		Eventually(func() bool {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(ir), ir)).To(Succeed())
+			return ir.Status.State == stateConflict &&
+				meta.IsStatusConditionFalse(ir.Status.Conditions, oyakov1alpha1.ConditionAccepted)
+		}).Should(BeTrue())
+		Eventually(func() error {
+			return parentHasExpectedInclude(ctx, parentNamespace, parentName, childNamespace, childName, prefix)
+		}).Should(Succeed())
+	})
+})
@@ -0,0 +1,119 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"strings"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"golang.org/x/xerrors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const managedIncludesAnnotation = "oyako.atelierhsn.com/managed-includes"
+
+// managedInclude records the provenance of an include added to a parent
+// HTTPProxy by oyako. Only includes with a matching record are ever modified
+// or removed by the controller. Headers is only set for children included
+// with header conditions, and ApprovedBy for parents requiring approval.
+type managedInclude struct {
+	Namespace  string                           `json:"namespace"`
+	Name       string                           `json:"name"`
+	UID        types.UID                        `json:"uid"`
+	Parent     string                           `json:"parent"`
+	Prefix     string                           `json:"prefix"`
+	Headers    []contourv1.HeaderMatchCondition `json:"headers,omitempty"`
+	AddedAt    v1.Time                          `json:"addedAt"`
+	ApprovedBy string                           `json:"approvedBy,omitempty"`
+}
+
+func (r *HTTPProxyReconciler) getManagedIncludes(parent *contourv1.HTTPProxy) ([]managedInclude, error) {
+	value := parent.Annotations[managedIncludesAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+	var records []managedInclude
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return nil, xerrors.Errorf("invalid %s annotation: %w", managedIncludesAnnotation, err)
+	}
+	return records, nil
+}
+
+func (r *HTTPProxyReconciler) setManagedIncludes(parent *contourv1.HTTPProxy, records []managedInclude) error {
+	if len(records) == 0 {
+		delete(parent.Annotations, managedIncludesAnnotation)
+		return nil
+	}
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if parent.Annotations == nil {
+		parent.Annotations = map[string]string{}
+	}
+	parent.Annotations[managedIncludesAnnotation] = string(value)
+	return nil
+}
+
+// findRecord returns the record of a child's include under prefix.
+func findRecord(records []managedInclude, prefix string) (managedInclude, bool) {
+	for _, record := range records {
+		if canonicalPrefix(record.Prefix) == prefix {
+			return record, true
+		}
+	}
+	return managedInclude{}, false
+}
+
+// recordedPrefixes returns the prefixes of a child's includes, as a
+// comma-separated list.
+func recordedPrefixes(records []managedInclude) string {
+	prefixes := make([]string, len(records))
+	for idx, record := range records {
+		prefixes[idx] = record.Prefix
+	}
+	return strings.Join(prefixes, ",")
+}
+
+// isLegacyInclude reports whether an include without a provenance record was
+// added by a previous version of oyako, in which case it is adopted. This is
+// only assumed when the child's bookkeeping annotations match the include,
+// or for children predating them, when the child holds the finalizer of
+// oyako and requests the prefix of the include in this parent.
+func (r *HTTPProxyReconciler) isLegacyInclude(childProxy *contourv1.HTTPProxy, parentRef string, include contourv1.Include) bool {
+	prefix := includePrefix(include)
+	if childProxy.Annotations[appliedParentAnnotation] == parentRef {
+		for _, applied := range splitList(childProxy.Annotations[appliedPrefixAnnotation]) {
+			if canonicalPrefix(applied) == prefix {
+				return true
+			}
+		}
+		return false
+	}
+	if !controllerutil.ContainsFinalizer(childProxy, finalizerName) || childProxy.Annotations[parentRefAnnotation] != parentRef {
+		return false
+	}
+	for _, requested := range r.childPrefixes(childProxy) {
+		if canonicalPrefix(requested) == prefix {
+			return true
+		}
+	}
+	return false
+}
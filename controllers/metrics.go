@@ -0,0 +1,109 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "oyako"
+
+var (
+	attachedChildren = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "attached_children",
+		Help:      "Number of children included in a parent HTTPProxy.",
+	}, []string{"parent"})
+
+	conflictingChildren = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "conflicting_children",
+		Help:      "Number of children of a parent HTTPProxy with a prefix that is not included because of a conflict.",
+	}, []string{"parent"})
+
+	pendingChildren = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_children",
+		Help:      "Number of children of a parent HTTPProxy waiting for it to exist, for approval or for a freeze to end.",
+	}, []string{"parent"})
+
+	rejectedInclusionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_inclusions_total",
+		Help:      "Total number of inclusions rejected, by reason.",
+	}, []string{"reason"})
+
+	parentUpdateFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "parent_update_failures_total",
+		Help:      "Total number of parent HTTPProxy updates that failed.",
+	})
+
+	parentUpdateConflictsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "parent_update_conflicts_total",
+		Help:      "Total number of parent HTTPProxy updates that were retried because of a conflict.",
+	})
+
+	finalizerCleanupsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "finalizer_cleanups_total",
+		Help:      "Total number of finalizers removed from child HTTPProxy objects.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		attachedChildren,
+		conflictingChildren,
+		pendingChildren,
+		rejectedInclusionsTotal,
+		parentUpdateFailuresTotal,
+		parentUpdateConflictsTotal,
+		finalizerCleanupsTotal,
+	)
+}
+
+// recordChildStates updates the gauges of the parent from the outcomes of its
+// children. Parents without any children are removed from the gauges.
+func recordChildStates(parentRef string, results []childResult) {
+	counts := make(map[string]int)
+	for _, result := range results {
+		// Children attached under only some of their prefixes are also
+		// counted under the states of the other prefixes.
+		states := map[string]bool{result.State: true}
+		for _, failure := range result.Failures {
+			states[failure.State] = true
+		}
+		for state := range states {
+			counts[state]++
+		}
+	}
+	gauges := map[string]*prometheus.GaugeVec{
+		stateAttached: attachedChildren,
+		stateConflict: conflictingChildren,
+		statePending:  pendingChildren,
+	}
+	for state, gauge := range gauges {
+		if len(results) == 0 {
+			gauge.DeleteLabelValues(parentRef)
+			continue
+		}
+		gauge.WithLabelValues(parentRef).Set(float64(counts[state]))
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	overlapPolicyAnnotation = "oyako.atelierhsn.com/overlap-policy"
+
+	// overlapPolicyAllow includes children regardless of overlapping
+	// prefixes.
+	overlapPolicyAllow = "allow"
+	// overlapPolicyWarn includes children with overlapping prefixes, but
+	// reports the overlap in their status and events.
+	overlapPolicyWarn = "warn"
+	// overlapPolicyDeny rejects children claiming a prefix that overlaps
+	// with the prefix of an older sibling.
+	overlapPolicyDeny = "deny"
+)
+
+// prefixClaim is a prefix claimed in a parent, along with its header
+// conditions as returned by describeHeaders.
+type prefixClaim struct {
+	Prefix  string
+	Headers string
+	Owner   client.ObjectKey
+	// Kept is set for conditions already included for a child, which are
+	// claimed before any child is checked.
+	Kept bool
+}
+
+// overlapPolicy returns the strictest overlap policy of the parent along with
+// where it comes from.
+func (p *parentPolicy) overlapPolicy() (string, string) {
+	policy, source := overlapPolicyAllow, ""
+	if p == nil {
+		return policy, source
+	}
+	strictness := map[string]int{overlapPolicyAllow: 0, overlapPolicyWarn: 1, overlapPolicyDeny: 2}
+	for _, rules := range p.Rules {
+		if strictness[rules.Spec.OverlapPolicy] > strictness[policy] {
+			policy, source = rules.Spec.OverlapPolicy, rules.Source
+		}
+	}
+	return policy, source
+}
+
+// prefixesOverlap reports whether requests for one of the prefixes may be
+// matched by the other. Contour matches prefixes as plain strings, so /api
+// overlaps with /api/v1 as well as with /apiv2.
+func prefixesOverlap(a, b string) bool {
+	return a != b && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a))
+}
+
+// routeClaims returns the prefixes of the routes of the parent, owned by the
+// parent itself.
+func routeClaims(parent *contourv1.HTTPProxy) []prefixClaim {
+	claims := make([]prefixClaim, 0, len(parent.Spec.Routes))
+	for _, route := range parent.Spec.Routes {
+		prefix, headers := routeConditions(route)
+		claims = append(claims, prefixClaim{
+			Prefix:  prefix,
+			Headers: describeHeaders(headers),
+			Owner:   client.ObjectKeyFromObject(parent),
+		})
+	}
+	return claims
+}
+
+// overlappingClaim returns the first claim of another HTTPProxy whose prefix
+// overlaps with prefix, if any. Claims only overlap when they have the same
+// header conditions, or when either of them has none, since requests
+// matching the header conditions of one also match the other. Conditions
+// already included are only checked against the claims of children checked
+// before, so that the oldest of two overlapping includes stays in place.
+func overlappingClaim(claims []prefixClaim, key client.ObjectKey, prefix, headers string, kept bool) *prefixClaim {
+	for idx := range claims {
+		claim := &claims[idx]
+		if kept && claim.Kept {
+			continue
+		}
+		sameHeaders := claim.Headers == headers || claim.Headers == "" || headers == ""
+		if claim.Owner != key && sameHeaders && prefixesOverlap(claim.Prefix, prefix) {
+			return claim
+		}
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	allowedNamespacesAnnotation        = "oyako.atelierhsn.com/allowed-namespaces"
+	allowedNamespaceSelectorAnnotation = "oyako.atelierhsn.com/allowed-namespace-selector"
+	childSelectorAnnotation            = "oyako.atelierhsn.com/child-selector"
+	reservedPrefixesAnnotation         = "oyako.atelierhsn.com/reserved-prefixes"
+	prefixPatternsAnnotation           = "oyako.atelierhsn.com/prefix-patterns"
+	maxChildrenAnnotation              = "oyako.atelierhsn.com/max-children"
+	maxPrefixesPerNamespaceAnnotation  = "oyako.atelierhsn.com/max-prefixes-per-namespace"
+)
+
+// policyRules are rules imposed on the children of a parent, along with
+// where they come from.
+type policyRules struct {
+	Source string
+	Spec   oyakov1alpha1.InclusionPolicySpec
+	// Err is set when the rules cannot be parsed, in which case no child is
+	// allowed.
+	Err error
+}
+
+// parentPolicy holds all the rules imposed on the children of a parent.
+// Children must satisfy every one of them to be included.
+type parentPolicy struct {
+	// Selected is set when an InclusionPolicy selects the parent.
+	Selected bool
+	Rules    []policyRules
+	// NamespaceLabels holds the labels of the namespaces of the children.
+	NamespaceLabels map[string]labels.Set
+}
+
+// +kubebuilder:rbac:groups=oyako.atelierhsn.com,resources=inclusionpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// getParentPolicy returns the rules imposed on the children of the parent by
+// its annotations and by the InclusionPolicy objects selecting it.
+func (r *HTTPProxyReconciler) getParentPolicy(ctx context.Context, parent *contourv1.HTTPProxy, children []*contourv1.HTTPProxy) (*parentPolicy, error) {
+	policy := &parentPolicy{NamespaceLabels: make(map[string]labels.Set)}
+	if rules, ok := annotationRules(parent); ok {
+		policy.Rules = append(policy.Rules, rules)
+	}
+	list := &oyakov1alpha1.InclusionPolicyList{}
+	if err := r.Client.List(ctx, list); err != nil {
+		return nil, err
+	}
+	for idx := range list.Items {
+		item := &list.Items[idx]
+		selected, err := r.policySelects(ctx, item, parent, policy.NamespaceLabels)
+		if err != nil {
+			return nil, err
+		}
+		if !selected {
+			continue
+		}
+		policy.Selected = true
+		rules := policyRules{
+			Source: fmt.Sprintf("InclusionPolicy %s", item.Name),
+			Spec:   *item.Spec.DeepCopy(),
+		}
+		if err := normalizePolicyPrefixes(&rules.Spec); err != nil {
+			rules.Err = err
+		}
+		policy.Rules = append(policy.Rules, rules)
+	}
+	if len(policy.Rules) == 0 {
+		return policy, nil
+	}
+	for _, child := range children {
+		if _, err := r.namespaceLabels(ctx, child.Namespace, policy.NamespaceLabels); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// annotationRules returns the rules declared by annotations on the parent,
+// if any.
+func annotationRules(parent *contourv1.HTTPProxy) (policyRules, bool) {
+	rules := policyRules{Source: "parent annotations"}
+	found := false
+	if value := parent.Annotations[allowedNamespacesAnnotation]; value != "" {
+		found = true
+		rules.Spec.AllowedNamespaces = splitList(value)
+	}
+	parseSelector := func(annotation string) *v1.LabelSelector {
+		value := parent.Annotations[annotation]
+		if value == "" {
+			return nil
+		}
+		found = true
+		selector, err := parseLabelSelector(value)
+		if err != nil && rules.Err == nil {
+			rules.Err = xerrors.Errorf("invalid %s annotation: %w", annotation, err)
+		}
+		return selector
+	}
+	if value := parent.Annotations[prefixPatternsAnnotation]; value != "" {
+		found = true
+		rules.Spec.PrefixPatterns = splitList(value)
+	}
+	parseLimit := func(annotation string) *int32 {
+		value := parent.Annotations[annotation]
+		if value == "" {
+			return nil
+		}
+		found = true
+		limit, err := strconv.ParseInt(value, 10, 32)
+		if err == nil && limit < 0 {
+			err = xerrors.New("negative limit")
+		}
+		if err != nil {
+			if rules.Err == nil {
+				rules.Err = xerrors.Errorf("invalid %s annotation: %w", annotation, err)
+			}
+			return nil
+		}
+		result := int32(limit)
+		return &result
+	}
+	if parent.Annotations[requireApprovalAnnotation] == "true" {
+		found = true
+		rules.Spec.RequireApproval = true
+	}
+	if value := parent.Annotations[overlapPolicyAnnotation]; value != "" {
+		found = true
+		switch value {
+		case overlapPolicyAllow, overlapPolicyWarn, overlapPolicyDeny:
+			rules.Spec.OverlapPolicy = value
+		default:
+			if rules.Err == nil {
+				rules.Err = xerrors.Errorf("invalid %s annotation: %q", overlapPolicyAnnotation, value)
+			}
+		}
+	}
+	rules.Spec.MaxChildren = parseLimit(maxChildrenAnnotation)
+	rules.Spec.MaxPrefixesPerNamespace = parseLimit(maxPrefixesPerNamespaceAnnotation)
+	rules.Spec.AllowedNamespaceSelector = parseSelector(allowedNamespaceSelectorAnnotation)
+	rules.Spec.ChildSelector = parseSelector(childSelectorAnnotation)
+	if value := parent.Annotations[reservedPrefixesAnnotation]; value != "" {
+		found = true
+		if err := parseReservedPrefixes(value, &rules.Spec); err != nil && rules.Err == nil {
+			rules.Err = xerrors.Errorf("invalid %s annotation: %w", reservedPrefixesAnnotation, err)
+		}
+	}
+	if err := normalizePolicyPrefixes(&rules.Spec); err != nil && rules.Err == nil {
+		rules.Err = err
+	}
+	return rules, found
+}
+
+// parseReservedPrefixes parses a JSON object mapping prefixes to the
+// namespaces that may claim them. Prefixes mapped to no namespace are
+// reserved, while the others are assigned to their namespaces. Prefixes are
+// normalized later on by normalizePolicyPrefixes.
+func parseReservedPrefixes(value string, spec *oyakov1alpha1.InclusionPolicySpec) error {
+	var reservations map[string][]string
+	if err := json.Unmarshal([]byte(value), &reservations); err != nil {
+		return err
+	}
+	prefixes := make([]string, 0, len(reservations))
+	for prefix := range reservations {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		namespaces := reservations[prefix]
+		if len(namespaces) == 0 {
+			spec.ReservedPrefixes = append(spec.ReservedPrefixes, prefix)
+			continue
+		}
+		spec.AssignedPrefixes = append(spec.AssignedPrefixes, oyakov1alpha1.PrefixAssignment{
+			Prefix:     prefix,
+			Namespaces: namespaces,
+		})
+	}
+	return nil
+}
+
+// normalizePolicyPrefixes normalizes the allowed, reserved and assigned
+// prefixes of spec in place, so that they compare equal to the normalized
+// prefixes claimed by children.
+func normalizePolicyPrefixes(spec *oyakov1alpha1.InclusionPolicySpec) error {
+	normalize := func(field string, prefixes []string) error {
+		for idx, prefix := range prefixes {
+			normalized, err := normalizePrefix(prefix)
+			if err != nil {
+				return xerrors.Errorf("invalid %s %q: %w", field, prefix, err)
+			}
+			prefixes[idx] = normalized
+		}
+		return nil
+	}
+	if err := normalize("allowed prefix", spec.AllowedPrefixes); err != nil {
+		return err
+	}
+	if err := normalize("reserved prefix", spec.ReservedPrefixes); err != nil {
+		return err
+	}
+	for idx := range spec.AssignedPrefixes {
+		assignment := &spec.AssignedPrefixes[idx]
+		normalized, err := normalizePrefix(assignment.Prefix)
+		if err != nil {
+			return xerrors.Errorf("invalid assigned prefix %q: %w", assignment.Prefix, err)
+		}
+		assignment.Prefix = normalized
+	}
+	return nil
+}
+
+// parseLabelSelector parses a label selector written as for kubectl. Unlike
+// v1.ParseToLabelSelector, it accepts the != operator.
+func parseLabelSelector(value string) (*v1.LabelSelector, error) {
+	requirements, err := labels.ParseToRequirements(value)
+	if err != nil {
+		return nil, err
+	}
+	selector := &v1.LabelSelector{}
+	for _, requirement := range requirements {
+		var operator v1.LabelSelectorOperator
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			operator = v1.LabelSelectorOpIn
+		case selection.NotEquals, selection.NotIn:
+			operator = v1.LabelSelectorOpNotIn
+		case selection.Exists:
+			operator = v1.LabelSelectorOpExists
+		case selection.DoesNotExist:
+			operator = v1.LabelSelectorOpDoesNotExist
+		default:
+			return nil, xerrors.Errorf("%q is not a valid label selector operator", requirement.Operator())
+		}
+		selector.MatchExpressions = append(selector.MatchExpressions, v1.LabelSelectorRequirement{
+			Key:      requirement.Key(),
+			Operator: operator,
+			Values:   requirement.Values().List(),
+		})
+	}
+	return selector, nil
+}
+
+// splitList splits a comma-separated annotation value, ignoring blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// policySelects reports whether the InclusionPolicy applies to the parent.
+// Policies with an invalid or empty parent selector are ignored, so that no
+// policy opens every HTTPProxy to inclusion.
+func (r *HTTPProxyReconciler) policySelects(ctx context.Context, item *oyakov1alpha1.InclusionPolicy, parent *contourv1.HTTPProxy, namespaceLabels map[string]labels.Set) (bool, error) {
+	if item.Spec.ParentSelector == nil {
+		return false, nil
+	}
+	selector, err := v1.LabelSelectorAsSelector(item.Spec.ParentSelector)
+	if err != nil {
+		r.Log.Error(err, "invalid parent selector", "inclusionpolicy", item.Name)
+		return false, nil
+	}
+	if selector.Empty() || !selector.Matches(labels.Set(parent.Labels)) {
+		return false, nil
+	}
+	if item.Spec.ParentNamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err = v1.LabelSelectorAsSelector(item.Spec.ParentNamespaceSelector)
+	if err != nil {
+		r.Log.Error(err, "invalid parent namespace selector", "inclusionpolicy", item.Name)
+		return false, nil
+	}
+	parentNamespaceLabels, err := r.namespaceLabels(ctx, parent.Namespace, namespaceLabels)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(parentNamespaceLabels), nil
+}
+
+// namespaceLabels returns the labels of the namespace, looking them up in
+// cache first.
+func (r *HTTPProxyReconciler) namespaceLabels(ctx context.Context, name string, cache map[string]labels.Set) (labels.Set, error) {
+	if namespaceLabels, ok := cache[name]; ok {
+		return namespaceLabels, nil
+	}
+	namespace := &corev1.Namespace{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: name}, namespace)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	cache[name] = labels.Set(namespace.Labels)
+	return cache[name], nil
+}
+
+// allowsInclusion reports whether the parent allows child inclusions, either
+// through its annotation or because an InclusionPolicy selects it. The
+// annotation takes precedence, so that inclusion can still be revoked on
+// parents selected by a policy.
+func (p *parentPolicy) allowsInclusion(parent *contourv1.HTTPProxy) bool {
+	if value, ok := parent.Annotations[allowInclusionAnnotation]; ok {
+		return value == "true"
+	}
+	return p != nil && p.Selected
+}
+
+// admit checks the child and the prefix it claims against the rules of the
+// parent. It returns false along with the outcome for the child if any rule
+// is broken.
+func (p *parentPolicy) admit(child *contourv1.HTTPProxy, parentRef, prefix string) (childResult, bool) {
+	if p == nil {
+		return childResult{}, true
+	}
+	for _, rules := range p.Rules {
+		if rules.Err != nil {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonInvalidPolicy,
+				Message: fmt.Sprintf("Unable to evaluate %s of parent %s: %v", rules.Source, parentRef, rules.Err),
+			}, false
+		}
+		if !rules.allowsNamespace(child.Namespace, p.NamespaceLabels[child.Namespace]) {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonNamespaceNotAllowed,
+				Message: fmt.Sprintf("Namespace %s may not include children in parent %s, per %s", child.Namespace, parentRef, rules.Source),
+			}, false
+		}
+		if !rules.allowsChild(child) {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonChildNotAllowed,
+				Message: fmt.Sprintf("HTTPProxy %s/%s does not match the child selector of parent %s, per %s", child.Namespace, child.Name, parentRef, rules.Source),
+			}, false
+		}
+		if reserved := rules.reservedPrefix(prefix); reserved != "" {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonPrefixReserved,
+				Message: fmt.Sprintf("Prefix %s in parent %s falls under reserved prefix %s, per %s", prefix, parentRef, reserved, rules.Source),
+			}, false
+		}
+		// Assigned prefixes are allowed for their namespaces, regardless of
+		// the allowed prefixes.
+		assignment := rules.assignedPrefix(prefix)
+		if assignment != nil && !containsString(assignment.Namespaces, child.Namespace) {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonPrefixAssigned,
+				Message: fmt.Sprintf("Prefix %s in parent %s falls under %s, which is assigned to namespaces %s, per %s", prefix, parentRef, assignment.Prefix, strings.Join(assignment.Namespaces, ", "), rules.Source),
+			}, false
+		}
+		if assignment == nil && !rules.allowsPrefix(prefix) {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonPrefixNotAllowed,
+				Message: fmt.Sprintf("Prefix %s is not allowed in parent %s, per %s", prefix, parentRef, rules.Source),
+			}, false
+		}
+		if assignment == nil && !rules.matchesPrefixPatterns(child, prefix) {
+			return childResult{
+				State:   stateRejected,
+				Reason:  reasonPrefixPatternMismatch,
+				Message: fmt.Sprintf("Prefix %s in parent %s does not match any of the patterns %s, per %s", prefix, parentRef, strings.Join(rules.Spec.PrefixPatterns, ", "), rules.Source),
+			}, false
+		}
+	}
+	return childResult{}, true
+}
+
+// maxChildren returns the lowest limit on the number of children included in
+// the parent along with where it comes from, or -1 if there is none.
+func (p *parentPolicy) maxChildren() (int, string) {
+	return p.lowestLimit(func(spec *oyakov1alpha1.InclusionPolicySpec) *int32 {
+		return spec.MaxChildren
+	})
+}
+
+// maxPrefixesPerNamespace returns the lowest limit on the number of prefixes
+// claimed by the children in a single namespace along with where it comes
+// from, or -1 if there is none.
+func (p *parentPolicy) maxPrefixesPerNamespace() (int, string) {
+	return p.lowestLimit(func(spec *oyakov1alpha1.InclusionPolicySpec) *int32 {
+		return spec.MaxPrefixesPerNamespace
+	})
+}
+
+func (p *parentPolicy) lowestLimit(get func(*oyakov1alpha1.InclusionPolicySpec) *int32) (int, string) {
+	limit, source := -1, ""
+	if p == nil {
+		return limit, source
+	}
+	for idx := range p.Rules {
+		value := get(&p.Rules[idx].Spec)
+		if value == nil {
+			continue
+		}
+		if n := int(*value); limit < 0 || n < limit {
+			limit, source = n, p.Rules[idx].Source
+		}
+	}
+	return limit, source
+}
+
+// allowsNamespace reports whether children in the namespace may be included.
+func (rules policyRules) allowsNamespace(namespace string, namespaceLabels labels.Set) bool {
+	spec := rules.Spec
+	if len(spec.AllowedNamespaces) == 0 && spec.AllowedNamespaceSelector == nil {
+		return true
+	}
+	if containsString(spec.AllowedNamespaces, namespace) {
+		return true
+	}
+	if spec.AllowedNamespaceSelector == nil {
+		return false
+	}
+	selector, err := v1.LabelSelectorAsSelector(spec.AllowedNamespaceSelector)
+	return err == nil && selector.Matches(namespaceLabels)
+}
+
+// allowsChild reports whether the child matches the child selector.
+func (rules policyRules) allowsChild(child *contourv1.HTTPProxy) bool {
+	if rules.Spec.ChildSelector == nil {
+		return true
+	}
+	selector, err := v1.LabelSelectorAsSelector(rules.Spec.ChildSelector)
+	return err == nil && selector.Matches(labels.Set(child.Labels))
+}
+
+// reservedPrefix returns the reserved prefix covering prefix, if any.
+func (rules policyRules) reservedPrefix(prefix string) string {
+	for _, reserved := range rules.Spec.ReservedPrefixes {
+		if prefix == reserved || reserved != "/" && isPathUnder(prefix, reserved) {
+			return reserved
+		}
+	}
+	return ""
+}
+
+// assignedPrefix returns the assignment covering prefix, if any.
+func (rules policyRules) assignedPrefix(prefix string) *oyakov1alpha1.PrefixAssignment {
+	for idx := range rules.Spec.AssignedPrefixes {
+		assignment := &rules.Spec.AssignedPrefixes[idx]
+		if isPathUnder(prefix, assignment.Prefix) {
+			return assignment
+		}
+	}
+	return nil
+}
+
+// allowsPrefix reports whether children may claim prefix.
+func (rules policyRules) allowsPrefix(prefix string) bool {
+	if len(rules.Spec.AllowedPrefixes) == 0 {
+		return true
+	}
+	for _, allowed := range rules.Spec.AllowedPrefixes {
+		if isPathUnder(prefix, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPrefixPatterns reports whether prefix is under one of the prefix
+// patterns, once expanded for the child.
+func (rules policyRules) matchesPrefixPatterns(child *contourv1.HTTPProxy, prefix string) bool {
+	if len(rules.Spec.PrefixPatterns) == 0 {
+		return true
+	}
+	replacer := strings.NewReplacer("{namespace}", child.Namespace, "{name}", child.Name)
+	for _, pattern := range rules.Spec.PrefixPatterns {
+		if isPathUnder(prefix, canonicalPrefix(replacer.Replace(pattern))) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether value is in values.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathUnder reports whether path is prefix itself or a path under it.
+func isPathUnder(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// parentRequestsForPolicy maps an InclusionPolicy to reconcile requests for
+// every parent referenced by a child, since the policy may select any of
+// them.
+func (r *HTTPProxyReconciler) parentRequestsForPolicy(_ client.Object) []reconcile.Request {
+	list := &contourv1.HTTPProxyList{}
+	if err := r.Client.List(context.Background(), list); err != nil {
+		r.Log.Error(err, "unable to list HTTPProxy")
+		return nil
+	}
+	return r.parentRequestsForChildren(list.Items, false)
+}
+
+// parentRequestsForNamespace maps a Namespace to reconcile requests for the
+// parents referenced by the HTTPProxy objects in it, since the allowed
+// namespace selectors of these parents depend on the namespace labels. The
+// HTTPProxy objects in the namespace are requested as well, as they may be
+// selected as parents by a parent namespace selector.
+func (r *HTTPProxyReconciler) parentRequestsForNamespace(obj client.Object) []reconcile.Request {
+	list := &contourv1.HTTPProxyList{}
+	if err := r.Client.List(context.Background(), list, client.InNamespace(obj.GetName())); err != nil {
+		r.Log.Error(err, "unable to list HTTPProxy", "namespace", obj.GetName())
+		return nil
+	}
+	return r.parentRequestsForChildren(list.Items, true)
+}
+
+// parentRequestsForChildren returns the deduplicated reconcile requests for
+// the parents of the given HTTPProxy objects, and for the objects themselves
+// if self is set.
+func (r *HTTPProxyReconciler) parentRequestsForChildren(items []contourv1.HTTPProxy, self bool) []reconcile.Request {
+	var requests []reconcile.Request
+	seen := make(map[reconcile.Request]bool)
+	for idx := range items {
+		candidates := r.parentRequestsForChild(&items[idx])
+		if self {
+			candidates = append(candidates, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&items[idx])})
+		}
+		for _, req := range candidates {
+			if seen[req] {
+				continue
+			}
+			seen[req] = true
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
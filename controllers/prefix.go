@@ -0,0 +1,62 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// prefixSegmentPattern matches the segments of a path prefix, which may only
+// contain unreserved characters, sub-delimiters other than the comma and the
+// equals sign, colons, at signs and percent-encoded octets. Commas and equals
+// signs separate the entries of the approved-inclusions annotation, and must
+// be percent-encoded.
+var prefixSegmentPattern = regexp.MustCompile(`^([A-Za-z0-9\-._~!$&'()*+;:@]|%[0-9A-Fa-f]{2})*$`)
+
+// normalizePrefix validates a path prefix and returns it with a leading
+// slash and without duplicate or trailing slashes, so that equivalent
+// prefixes compare equal.
+func normalizePrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", xerrors.New("empty prefix")
+	}
+	var segments []string
+	for _, segment := range strings.Split(prefix, "/") {
+		switch {
+		case segment == "":
+			continue
+		case segment == "." || segment == "..":
+			return "", xerrors.Errorf("prefix must not contain %q segments", segment)
+		case !prefixSegmentPattern.MatchString(segment):
+			return "", xerrors.Errorf("prefix segment %q contains characters not allowed in a path", segment)
+		}
+		segments = append(segments, segment)
+	}
+	return "/" + strings.Join(segments, "/"), nil
+}
+
+// canonicalPrefix returns the normalized form of a prefix found in an
+// existing include or record, or the prefix itself if it is malformed.
+func canonicalPrefix(prefix string) string {
+	if normalized, err := normalizePrefix(prefix); err == nil {
+		return normalized
+	}
+	return prefix
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+)
+
+const (
+	revocationModeAnnotation = "oyako.atelierhsn.com/revocation-mode"
+
+	// RevocationModeDetach removes all includes managed by oyako from a parent
+	// that no longer allows child inclusions.
+	RevocationModeDetach = "detach"
+	// RevocationModeFreeze leaves includes managed by oyako in place on a
+	// parent that no longer allows child inclusions, without updating them.
+	RevocationModeFreeze = "freeze"
+)
+
+// IsValidRevocationMode reports whether mode is a known revocation mode.
+func IsValidRevocationMode(mode string) bool {
+	return mode == RevocationModeDetach || mode == RevocationModeFreeze
+}
+
+// revocationMode returns the revocation mode of the parent, falling back to
+// the controller's default.
+func (r *HTTPProxyReconciler) revocationMode(parent *contourv1.HTTPProxy) string {
+	if mode := parent.Annotations[revocationModeAnnotation]; IsValidRevocationMode(mode) {
+		return mode
+	}
+	if IsValidRevocationMode(r.DefaultRevocationMode) {
+		return r.DefaultRevocationMode
+	}
+	return RevocationModeFreeze
+}
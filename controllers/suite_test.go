@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
@@ -55,7 +56,10 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "third-party")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			filepath.Join("..", "config", "crd", "third-party"),
+		},
 		ErrorIfCRDPathMissing: true,
 	}
 	var err error
@@ -65,6 +69,7 @@ var _ = BeforeSuite(func() {
 
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(contourv1.AddToScheme(scheme))
+	utilruntime.Must(oyakov1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 
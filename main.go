@@ -32,6 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	oyakov1alpha1 "atelierhsn.com/oyako/api/v1alpha1"
 	"atelierhsn.com/oyako/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -44,6 +45,7 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(contourv1.AddToScheme(scheme))
+	utilruntime.Must(oyakov1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
@@ -52,11 +54,19 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var revocationMode string
+	var freezeWindows string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&revocationMode, "revocation-mode", controllers.RevocationModeFreeze,
+		"What to do with included children when a parent no longer allows child inclusions, unless overridden on the parent. "+
+			"One of detach or freeze.")
+	flag.StringVar(&freezeWindows, "freeze-windows", "",
+		"Comma-separated periods during which no parent is modified, each as RFC 3339 start and end times separated by a slash. "+
+			"Includes of children being deleted are removed regardless.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +75,16 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if !controllers.IsValidRevocationMode(revocationMode) {
+		setupLog.Error(nil, "invalid revocation mode", "revocation-mode", revocationMode)
+		os.Exit(1)
+	}
+	windows, err := controllers.ParseFreezeWindows(freezeWindows)
+	if err != nil {
+		setupLog.Error(err, "invalid freeze windows", "freeze-windows", freezeWindows)
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -79,11 +99,22 @@ func main() {
 	}
 
 	if err = (&controllers.HTTPProxyReconciler{
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controllers").WithName("HTTPProxy"),
+		Scheme:                mgr.GetScheme(),
+		Recorder:              mgr.GetEventRecorderFor("oyako"),
+		DefaultRevocationMode: revocationMode,
+		FreezeWindows:         windows,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HTTPProxy")
+		os.Exit(1)
+	}
+	if err = (&controllers.InclusionRequestReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("HTTPProxy"),
+		Log:    ctrl.Log.WithName("controllers").WithName("InclusionRequest"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "HTTPProxy")
+		setupLog.Error(err, "unable to create controller", "controller", "InclusionRequest")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder